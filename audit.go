@@ -1,13 +1,25 @@
 package office365
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 
 	"github.com/orlangure/go-office365/schema"
 )
 
+// ErrUntrustedContentURI is returned when a content URI does not point to the
+// audit endpoint of the client BaseURL. The request is not sent, so that the
+// access token is not leaked to another host.
+var ErrUntrustedContentURI = errors.New("content URI does not point to the client audit endpoint")
+
 // AuditService .
 type AuditService service
 
@@ -17,170 +29,285 @@ type AuditService service
 // To retrieve a content blob, make a GET request against the corresponding content URI that is included
 // in the list of available content and in the notifications sent to a webhook.
 // The returned content will be a collection of one more actions or events in JSON format.
-func (s *AuditService) List(ctx context.Context, contentID string, addExtendedSchema bool) (*Response, []interface{}, error) {
+func (s *AuditService) List(ctx context.Context, contentID string, addExtendedSchema bool, opts ...CallOption) (*Response, []interface{}, error) {
 	if contentID == "" {
 		return nil, nil, fmt.Errorf("ContentID must not be empty")
 	}
-	path := fmt.Sprintf("audit/%s", contentID)
-	req, err := s.client.newRequest("GET", path, nil, nil)
+	settings := s.client.newCallSettings(opts)
+	req, err := s.client.newAuditRequest(contentID, settings)
 	if err != nil {
 		return nil, nil, err
 	}
+	return s.list(ctx, req, settings, addExtendedSchema)
+}
 
-	var records []json.RawMessage
-	resp, err := s.client.do(ctx, req, &records)
+// ListURI returns a list of events or actions using the content URI found in
+// Content.ContentURI or in webhook notifications, query parameters included.
+// The URI must point to the audit endpoint of the client BaseURL, ErrUntrustedContentURI
+// is returned otherwise.
+// The client PublisherIdentifier is added unless the URI already holds one,
+// the one set with WithPubIdentifier replaces it.
+func (s *AuditService) ListURI(ctx context.Context, contentURI string, addExtendedSchema bool, opts ...CallOption) (*Response, []interface{}, error) {
+	settings := s.client.newCallSettings(opts)
+	req, err := s.client.newAuditURIRequest(contentURI, settings)
 	if err != nil {
-		return resp, nil, err
+		return nil, nil, err
 	}
+	return s.list(ctx, req, settings, addExtendedSchema)
+}
 
-	var out []interface{}
-	for _, raw := range records {
-		var data interface{}
+// newAuditRequest returns the request fetching the content blob identified by contentID.
+func (c *Client) newAuditRequest(contentID string, settings *callSettings) (*http.Request, error) {
+	if contentID == "" {
+		return nil, fmt.Errorf("ContentID must not be empty")
+	}
+	params := NewQueryParams()
+	params.AddPubIdentifier(settings.pubIdentifier)
+	req, err := c.newRequest("GET", fmt.Sprintf("audit/%s", contentID), params.Values, nil)
+	if err != nil {
+		return nil, err
+	}
+	settings.apply(req)
+	return req, nil
+}
 
-		var r schema.AuditRecord
-		if err := json.Unmarshal(raw, &r); err != nil {
-			return resp, nil, err
-		}
-		data = r
+// newAuditURIRequest returns the request fetching the content blob found at contentURI.
+// The PublisherIdentifier of the URI is replaced only if overridden by the call options.
+func (c *Client) newAuditURIRequest(contentURI string, settings *callSettings) (*http.Request, error) {
+	u, err := c.contentURL(contentURI)
+	if err != nil {
+		return nil, err
+	}
+	if settings.pubIdentifierSet {
+		params := u.Query()
+		params.Set("PublisherIdentifier", settings.pubIdentifier)
+		u.RawQuery = params.Encode()
+	}
+	req, err := c.newRequestURL("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	settings.apply(req)
+	return req, nil
+}
 
-		if addExtendedSchema {
-			AddExtendedSchema(r.RecordType, raw, &data)
-		}
-		out = append(out, data)
+// contentURL parses and validates a content URI against the client BaseURL.
+func (c *Client) contentURL(contentURI string) (*url.URL, error) {
+	if contentURI == "" {
+		return nil, fmt.Errorf("ContentURI must not be empty")
+	}
+	u, err := url.Parse(contentURI)
+	if err != nil {
+		return nil, err
 	}
-	return resp, out, err
+	auditPath := c.getURL("audit/", nil).Path
+	if !strings.EqualFold(u.Scheme, c.BaseURL.Scheme) ||
+		!strings.EqualFold(u.Host, c.BaseURL.Host) ||
+		u.User != nil ||
+		len(u.Path) <= len(auditPath) ||
+		!strings.EqualFold(u.Path[:len(auditPath)], auditPath) ||
+		strings.Contains(u.Path[len(auditPath):], "/") {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedContentURI, contentURI)
+	}
+
+	params := u.Query()
+	if params.Get("PublisherIdentifier") == "" && c.pubIdentifier != "" {
+		params.Set("PublisherIdentifier", c.pubIdentifier)
+		u.RawQuery = params.Encode()
+	}
+	return u, nil
 }
 
-// AddExtendedSchema .
-func AddExtendedSchema(r *schema.AuditLogRecordType, raw json.RawMessage, data *interface{}) {
-	switch *r {
-	case schema.ExchangeAdminType:
-		var d schema.ExchangeAdmin
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.ExchangeItemType:
-		var d schema.ExchangeItem
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.ExchangeItemGroupType:
-	case schema.SharePointType:
-		var d schema.Sharepoint
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.SharePointFileOperationType:
-		var d schema.SharepointFileOperations
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.AzureActiveDirectoryType:
-		var d schema.AzureActiveDirectory
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.AzureActiveDirectoryAccountLogonType:
-		var d schema.AzureActiveDirectoryAccountLogon
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.DataCenterSecurityCmdletType:
-		var d schema.DataCenterSecurityCmdlet
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.ComplianceDLPSharePointType:
-	case schema.ComplianceDLPExchangeType:
-	case schema.SharePointSharingOperationType:
-		var d schema.SharepointSharing
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.AzureActiveDirectoryStsLogonType:
-		var d schema.AzureActiveDirectorySTSLogon
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.SecurityComplianceCenterEOPCmdletType:
-		var d schema.SecurityComplianceCenter
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.PowerBIAuditType:
-		var d schema.PowerBI
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.CRMType:
-	case schema.YammerType:
-		var d schema.Yammer
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.SkypeForBusinessCmdletsType:
-	case schema.DiscoveryType:
-	case schema.MicrosoftTeamsType:
-		var d schema.MicrosoftTeams
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.ThreatIntelligenceType:
-		var d schema.ATP
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
-		}
-	case schema.MailSubmissionType:
-	case schema.MicrosoftFlowType:
-	case schema.AeDType:
-	case schema.MicrosoftStreamType:
-	case schema.ComplianceDLPSharePointClassificationType:
-	case schema.ProjectType:
-		var d schema.Project
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+// Stream decodes the events or actions of a content blob one at a time,
+// calling fn for each of them as soon as it is decoded.
+// The body is never held in memory as a whole, which keeps memory usage
+// bounded for large blobs.
+// Decoding stops at the first error returned by fn, which is returned by Stream.
+func (s *AuditService) Stream(ctx context.Context, contentID string, addExtendedSchema bool, fn func(interface{}) error, opts ...CallOption) (*Response, error) {
+	settings := s.client.newCallSettings(opts)
+	req, err := s.client.newAuditRequest(contentID, settings)
+	if err != nil {
+		return nil, err
+	}
+	return s.stream(ctx, req, settings, addExtendedSchema, fn)
+}
+
+// StreamURI is like Stream, using a content URI as ListURI does.
+func (s *AuditService) StreamURI(ctx context.Context, contentURI string, addExtendedSchema bool, fn func(interface{}) error, opts ...CallOption) (*Response, error) {
+	settings := s.client.newCallSettings(opts)
+	req, err := s.client.newAuditURIRequest(contentURI, settings)
+	if err != nil {
+		return nil, err
+	}
+	return s.stream(ctx, req, settings, addExtendedSchema, fn)
+}
+
+// list sends the request and decodes the audit records returned.
+func (s *AuditService) list(ctx context.Context, req *http.Request, settings *callSettings, addExtendedSchema bool) (*Response, []interface{}, error) {
+	var out []interface{}
+	resp, err := s.stream(ctx, req, settings, addExtendedSchema, func(record interface{}) error {
+		out = append(out, record)
+		return nil
+	})
+	if err != nil {
+		return resp, nil, err
+	}
+	return resp, out, nil
+}
+
+// stream sends the request and decodes the audit records returned one at a time.
+func (s *AuditService) stream(ctx context.Context, req *http.Request, settings *callSettings, addExtendedSchema bool, fn func(interface{}) error) (*Response, error) {
+	ctx, cancel := withCallSettings(ctx, settings)
+	defer cancel()
+	ctx, span := s.client.startOperation(ctx, OperationAuditFetch, nil)
+	var records int
+	resp, err := s.client.doStream(ctx, req, func(body io.Reader) error {
+		return decodeAuditRecords(body, addExtendedSchema, func(record interface{}) error {
+			records++
+			return fn(record)
+		})
+	})
+	span.end(ctx, resp, err, attrRecordCount.Int(records))
+	return resp, err
+}
+
+// decodeAuditRecords decodes a json array of audit records token by token.
+func decodeAuditRecords(r io.Reader, addExtendedSchema bool, fn func(interface{}) error) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		// null
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("audit records must be a json array, got %v", tok)
+	}
+
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
 		}
-	case schema.SharePointListOperationType:
-	case schema.DataGovernanceType:
-	case schema.SecurityComplianceAlertsType:
-		var d schema.SecurityComplianceAlerts
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+		record, err := decodeAuditRecord(raw, addExtendedSchema)
+		if err != nil {
+			return err
 		}
-	case schema.SecurityComplianceInsightsType:
-	case schema.WorkplaceAnalyticsType:
-		var d schema.WorkplaceAnalytics
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+		if err := fn(record); err != nil {
+			return err
 		}
-	case schema.PowerAppsAppType:
-	case schema.ThreatIntelligenceAtpContentType:
-		var d schema.ATP
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// decodeAuditRecord decodes a single audit record, straight into its extended schema
+// if requested and known, into the common schema otherwise.
+// A record which cannot be decoded into its schema is an error either way.
+func decodeAuditRecord(raw json.RawMessage, addExtendedSchema bool) (interface{}, error) {
+	if addExtendedSchema {
+		recordType, err := peekRecordType(raw)
+		if err != nil {
+			return nil, err
 		}
-	case schema.TeamsHealthcareType:
-	case schema.SharePointListItemOperationType:
-		var d schema.SharepointBase
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+		if recordType != nil {
+			if newSchema, ok := extendedSchemas[*recordType]; ok {
+				d := newSchema()
+				if err := json.Unmarshal(raw, d); err != nil {
+					return nil, err
+				}
+				return reflect.ValueOf(d).Elem().Interface(), nil
+			}
 		}
-	case schema.SharePointContentTypeOperationType:
-		var d schema.SharepointBase
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+	}
+
+	var r schema.AuditRecord
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// peekRecordType returns the RecordType of a raw audit record, nil if it has none.
+// Only the members up to RecordType are scanned.
+func peekRecordType(raw json.RawMessage) (*schema.AuditLogRecordType, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		// null
+		return nil, nil
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("audit record must be a json object, got %v", tok)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
 		}
-	case schema.SharePointFieldOperationType:
-		var d schema.SharepointBase
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+		if name, ok := key.(string); ok && strings.EqualFold(name, "RecordType") {
+			var recordType *schema.AuditLogRecordType
+			if err := dec.Decode(&recordType); err != nil {
+				return nil, err
+			}
+			return recordType, nil
 		}
-	case schema.AirInvestigationType:
-	case schema.QuarantineType:
-		var d schema.Quarantine
-		if err := json.Unmarshal(raw, &d); err == nil {
-			*data = d
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
 		}
-	case schema.MicrosoftFormsType:
+	}
+	return nil, nil
+}
+
+// extendedSchemas returns, for each AuditLogRecordType having one,
+// a pointer to a new value of its extended schema.
+var extendedSchemas = map[schema.AuditLogRecordType]func() interface{}{
+	schema.ExchangeAdminType:                     func() interface{} { return &schema.ExchangeAdmin{} },
+	schema.ExchangeItemType:                      func() interface{} { return &schema.ExchangeItem{} },
+	schema.SharePointType:                        func() interface{} { return &schema.Sharepoint{} },
+	schema.SharePointFileOperationType:           func() interface{} { return &schema.SharepointFileOperations{} },
+	schema.AzureActiveDirectoryType:              func() interface{} { return &schema.AzureActiveDirectory{} },
+	schema.AzureActiveDirectoryAccountLogonType:  func() interface{} { return &schema.AzureActiveDirectoryAccountLogon{} },
+	schema.DataCenterSecurityCmdletType:          func() interface{} { return &schema.DataCenterSecurityCmdlet{} },
+	schema.SharePointSharingOperationType:        func() interface{} { return &schema.SharepointSharing{} },
+	schema.AzureActiveDirectoryStsLogonType:      func() interface{} { return &schema.AzureActiveDirectorySTSLogon{} },
+	schema.SecurityComplianceCenterEOPCmdletType: func() interface{} { return &schema.SecurityComplianceCenter{} },
+	schema.PowerBIAuditType:                      func() interface{} { return &schema.PowerBI{} },
+	schema.YammerType:                            func() interface{} { return &schema.Yammer{} },
+	schema.MicrosoftTeamsType:                    func() interface{} { return &schema.MicrosoftTeams{} },
+	schema.ThreatIntelligenceType:                func() interface{} { return &schema.ATP{} },
+	schema.ProjectType:                           func() interface{} { return &schema.Project{} },
+	schema.SecurityComplianceAlertsType:          func() interface{} { return &schema.SecurityComplianceAlerts{} },
+	schema.WorkplaceAnalyticsType:                func() interface{} { return &schema.WorkplaceAnalytics{} },
+	schema.ThreatIntelligenceAtpContentType:      func() interface{} { return &schema.ATP{} },
+	schema.SharePointListItemOperationType:       func() interface{} { return &schema.SharepointBase{} },
+	schema.SharePointContentTypeOperationType:    func() interface{} { return &schema.SharepointBase{} },
+	schema.SharePointFieldOperationType:          func() interface{} { return &schema.SharepointBase{} },
+	schema.QuarantineType:                        func() interface{} { return &schema.Quarantine{} },
+}
+
+// AddExtendedSchema replaces data with the extended schema of the record type,
+// decoded from raw. data is left untouched if the record type has no
+// extended schema or if decoding fails.
+func AddExtendedSchema(r *schema.AuditLogRecordType, raw json.RawMessage, data *interface{}) {
+	if r == nil {
+		return
+	}
+	newSchema, ok := extendedSchemas[*r]
+	if !ok {
+		return
+	}
+	d := newSchema()
+	if err := json.Unmarshal(raw, d); err == nil {
+		*data = reflect.ValueOf(d).Elem().Interface()
 	}
 }
@@ -1,10 +1,13 @@
 package office365
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -72,3 +75,202 @@ func TestAudit(t *testing.T) {
 		})
 	}
 }
+
+func TestAuditURI(t *testing.T) {
+
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	tp := schema.ComplianceDLPExchangeType
+	record := schema.AuditRecord{ID: String("qqqqqqq"), RecordType: &tp}
+
+	var gotQuery url.Values
+	auditURL := client.getURL("audit/", nil)
+	mux.HandleFunc(auditURL.Path, func(w http.ResponseWriter, r *http.Request) {
+		EnforceMethod(t, r, "GET")
+		gotQuery = r.URL.Query()
+		if err := json.NewEncoder(w).Encode([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	base := client.getURL("audit/abc", nil)
+	withParams := *base
+	withParams.RawQuery = "extra=1&PublisherIdentifier=custom"
+	foreign := *base
+	foreign.Host = "attacker.example.com"
+
+	cases := []struct {
+		ContentURI        string
+		WantPubIdentifier string
+		WantError         error
+	}{
+		{ContentURI: base.String(), WantPubIdentifier: client.pubIdentifier},
+		{ContentURI: withParams.String(), WantPubIdentifier: "custom"},
+		{ContentURI: foreign.String(), WantError: ErrUntrustedContentURI},
+		{ContentURI: client.getURL("subscriptions/list", nil).String(), WantError: ErrUntrustedContentURI},
+		{ContentURI: client.getURL("audit/abc/../../subscriptions/list", nil).String(), WantError: ErrUntrustedContentURI},
+		{ContentURI: "/api/v1.0/test-tenandID/activity/feed/audit/abc", WantError: ErrUntrustedContentURI},
+	}
+
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			gotQuery = nil
+			_, records, err := client.Audit.ListURI(context.Background(), c.ContentURI, false)
+			if !errors.Is(err, c.WantError) {
+				t.Fatalf("got error %v but want %v", err, c.WantError)
+			}
+			if c.WantError != nil {
+				if gotQuery != nil {
+					t.Errorf("request must not be sent for an untrusted URI")
+				}
+				return
+			}
+			testDeep(t, records, []interface{}{record})
+			if got := gotQuery.Get("PublisherIdentifier"); got != c.WantPubIdentifier {
+				t.Errorf("got PublisherIdentifier %q but want %q", got, c.WantPubIdentifier)
+			}
+		})
+	}
+}
+
+func TestAuditStream(t *testing.T) {
+
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	adminType := schema.ExchangeAdminType
+	crmType := schema.CRMType
+	url := client.getURL("audit/abc", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Id": "1", "RecordType": 1, "ExternalAccess": true},
+			{"Id": "2", "RecordType": "CRM"},
+			{"Id": "3", "RecordType": 1}
+		]`)
+	})
+
+	var records []interface{}
+	_, err := client.Audit.Stream(context.Background(), "abc", true, func(record interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error occurred running Audit.Stream: %v", err)
+	}
+	want := []interface{}{
+		schema.ExchangeAdmin{AuditRecord: schema.AuditRecord{ID: String("1"), RecordType: &adminType}, ExternalAccess: Bool(true)},
+		schema.AuditRecord{ID: String("2"), RecordType: &crmType},
+		schema.ExchangeAdmin{AuditRecord: schema.AuditRecord{ID: String("3"), RecordType: &adminType}},
+	}
+	testDeep(t, records, want)
+
+	// decoding stops at the first error returned by the callback
+	wantErr := errors.New("handler failure")
+	calls := 0
+	_, err = client.Audit.Stream(context.Background(), "abc", false, func(record interface{}) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr || calls != 1 {
+		t.Errorf("got error %v after %d calls but want %v after 1 call", err, calls, wantErr)
+	}
+}
+
+func TestDecodeAuditRecord(t *testing.T) {
+	adminType := schema.ExchangeAdminType
+	crmType := schema.CRMType
+	cases := []struct {
+		Name     string
+		Raw      string
+		Extended bool
+		Want     interface{}
+		WantErr  bool
+	}{
+		{"extended", `{"Id": "1", "RecordType": 1, "ExternalAccess": true}`, true,
+			schema.ExchangeAdmin{AuditRecord: schema.AuditRecord{ID: String("1"), RecordType: &adminType}, ExternalAccess: Bool(true)}, false},
+		{"record type last", `{"ExternalAccess": true, "Id": "1", "RecordType": 1}`, true,
+			schema.ExchangeAdmin{AuditRecord: schema.AuditRecord{ID: String("1"), RecordType: &adminType}, ExternalAccess: Bool(true)}, false},
+		{"no extended schema", `{"Id": "2", "RecordType": "CRM"}`, true, schema.AuditRecord{ID: String("2"), RecordType: &crmType}, false},
+		{"no record type", `{"Id": "3"}`, true, schema.AuditRecord{ID: String("3")}, false},
+		{"common", `{"Id": "1", "RecordType": 1, "ExternalAccess": true}`, false, schema.AuditRecord{ID: String("1"), RecordType: &adminType}, false},
+		{"invalid common field", `{"Id": 1, "RecordType": 1}`, false, nil, true},
+		{"invalid common field in extended schema", `{"Id": 1, "RecordType": 1}`, true, nil, true},
+		{"invalid extended field", `{"Id": "1", "RecordType": 1, "ExternalAccess": "yes"}`, true, nil, true},
+		{"invalid record type", `{"Id": "1", "RecordType": true}`, true, nil, true},
+		{"not an object", `[]`, true, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got, err := decodeAuditRecord(json.RawMessage(tc.Raw), tc.Extended)
+			if (err != nil) != tc.WantErr {
+				t.Fatalf("got error %v but want error %v", err, tc.WantErr)
+			}
+			if err == nil {
+				testDeep(t, got, tc.Want)
+			}
+		})
+	}
+}
+
+// benchmarkAuditBlob returns a json array of n Exchange audit records.
+func benchmarkAuditBlob(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"Id": "%d", "RecordType": 2, "CreationTime": "2020-01-01T00:00:00", "Operation": "MailItemsAccessed", "OrganizationId": "org", "UserType": 0, "UserKey": "key", "Workload": "Exchange", "ResultStatus": "Succeeded", "ObjectId": "object", "UserId": "user@example.com", "ClientIP": "127.0.0.1", "LogonType": 0, "MailboxOwnerUPN": "owner@example.com", "ClientInfoString": "Client=REST;Client=RESTSystem;"}`, i)
+	}
+	buf.WriteString("]")
+	return buf.Bytes()
+}
+
+// BenchmarkAuditDecodeBuffered measures the decoding strategy used before streaming:
+// the whole body is held as raw records, which are unmarshalled twice and collected.
+func BenchmarkAuditDecodeBuffered(b *testing.B) {
+	blob := benchmarkAuditBlob(5000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		var records []json.RawMessage
+		if err := json.NewDecoder(bytes.NewReader(blob)).Decode(&records); err != nil {
+			b.Fatal(err)
+		}
+		var out []interface{}
+		for _, raw := range records {
+			var r schema.AuditRecord
+			if err := json.Unmarshal(raw, &r); err != nil {
+				b.Fatal(err)
+			}
+			var data interface{} = r
+			AddExtendedSchema(r.RecordType, raw, &data)
+			out = append(out, data)
+		}
+		if len(out) != 5000 {
+			b.Fatalf("got %d records", len(out))
+		}
+	}
+}
+
+// BenchmarkAuditDecodeStreaming measures decoding records one at a time,
+// as done by AuditService.Stream.
+func BenchmarkAuditDecodeStreaming(b *testing.B) {
+	blob := benchmarkAuditBlob(5000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		count := 0
+		err := decodeAuditRecords(bytes.NewReader(blob), true, func(record interface{}) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if count != 5000 {
+			b.Fatalf("got %d records", count)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds the limit set with WithMaxResponseSize.
+var ErrResponseTooLarge = errors.New("response body exceeds the size limit")
+
+// CallOption configures a single call of a service method.
+type CallOption func(*callSettings)
+
+// callSettings holds the settings of a single call.
+type callSettings struct {
+	timeout       time.Duration
+	pubIdentifier string
+	// pubIdentifierSet reports whether pubIdentifier was set with WithPubIdentifier.
+	pubIdentifierSet bool
+	header           http.Header
+	query            url.Values
+	maxBodySize      int64
+}
+
+// WithCallTimeout bounds the whole call: every request sent, pages, retries,
+// rate limit waits and body decoding included. It replaces the timeout of the
+// client http.Client, which bounds each request otherwise, so it can be longer than it.
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(s *callSettings) {
+		s.timeout = d
+	}
+}
+
+// WithPubIdentifier overrides the PublisherIdentifier of the client for the call.
+// It also replaces the one held by content URIs, and the requests go through
+// the RateLimiter of that PublisherIdentifier, see WithRateLimiters.
+func WithPubIdentifier(pubIdentifier string) CallOption {
+	return func(s *callSettings) {
+		s.pubIdentifier = pubIdentifier
+		s.pubIdentifierSet = true
+	}
+}
+
+// WithHeader adds a header to the requests sent by the call.
+func WithHeader(key, value string) CallOption {
+	return func(s *callSettings) {
+		if s.header == nil {
+			s.header = make(http.Header)
+		}
+		s.header.Add(key, value)
+	}
+}
+
+// WithQueryParam adds a query parameter to the requests sent by the call.
+func WithQueryParam(key, value string) CallOption {
+	return func(s *callSettings) {
+		if s.query == nil {
+			s.query = make(url.Values)
+		}
+		s.query.Add(key, value)
+	}
+}
+
+// WithMaxResponseSize limits the size of the response bodies read by the call.
+// ErrResponseTooLarge is returned once a body exceeds n bytes.
+func WithMaxResponseSize(n int64) CallOption {
+	return func(s *callSettings) {
+		s.maxBodySize = n
+	}
+}
+
+// newCallSettings applies the options on top of the client defaults.
+func (c *Client) newCallSettings(opts []CallOption) *callSettings {
+	s := &callSettings{pubIdentifier: c.pubIdentifier}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// apply adds the extra headers and query parameters to the request.
+func (s *callSettings) apply(req *http.Request) {
+	for k, v := range s.header {
+		req.Header[k] = append(req.Header[k], v...)
+	}
+	if len(s.query) > 0 {
+		params := req.URL.Query()
+		for k, v := range s.query {
+			params[k] = append(params[k], v...)
+		}
+		req.URL.RawQuery = params.Encode()
+	}
+}
+
+type callSettingsKey struct{}
+
+// withCallSettings returns a copy of ctx holding the call settings used by do,
+// and bounded by the call timeout, if any.
+// The returned function must be called once the call returns.
+func withCallSettings(ctx context.Context, s *callSettings) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, callSettingsKey{}, s)
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return ctx, func() {}
+}
+
+// callSettingsFromContext returns the call settings held by ctx, nil if none.
+func callSettingsFromContext(ctx context.Context) *callSettings {
+	s, _ := ctx.Value(callSettingsKey{}).(*callSettings)
+	return s
+}
+
+// httpClient returns the http.Client sending the requests of the call.
+func (c *Client) httpClient(s *callSettings) *http.Client {
+	if s == nil || s.timeout <= 0 {
+		return c.client
+	}
+	// the context deadline set by withCallSettings takes over
+	hc := *c.client
+	hc.Timeout = 0
+	return &hc
+}
+
+// limitedBody reads up to n bytes from r, failing with ErrResponseTooLarge
+// if more are available.
+type limitedBody struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// probe for data past the limit
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// limitBody limits the body according to the call settings.
+func (s *callSettings) limitBody(resp *http.Response) (io.Reader, error) {
+	if s == nil || s.maxBodySize <= 0 {
+		return resp.Body, nil
+	}
+	if resp.ContentLength > s.maxBodySize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrResponseTooLarge, resp.ContentLength)
+	}
+	return &limitedBody{r: resp.Body, n: s.maxBodySize}, nil
+}
@@ -0,0 +1,143 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestCallOptionsRequest(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("PublisherIdentifier"); got != "other-publisher" {
+			t.Errorf("got PublisherIdentifier %q but want other-publisher", got)
+		}
+		if got := r.URL.Query().Get("extra"); got != "value" {
+			t.Errorf("got extra query param %q but want value", got)
+		}
+		if got := r.Header.Get("X-Extra"); got != "header" {
+			t.Errorf("got X-Extra header %q but want header", got)
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	_, _, err := client.Subscription.List(context.Background(),
+		WithPubIdentifier("other-publisher"),
+		WithQueryParam("extra", "value"),
+		WithHeader("X-Extra", "header"),
+	)
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+}
+
+func TestCallOptionsPubIdentifierURI(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	var got []string
+	url := client.getURL("audit/abc", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Query().Get("PublisherIdentifier"))
+		fmt.Fprint(w, `[]`)
+	})
+
+	uri := url.String() + "?PublisherIdentifier=from-uri"
+	if _, _, err := client.Audit.ListURI(context.Background(), uri, false); err != nil {
+		t.Fatalf("error occurred running Audit.ListURI: %v", err)
+	}
+	if _, _, err := client.Audit.ListURI(context.Background(), uri, false, WithPubIdentifier("override")); err != nil {
+		t.Fatalf("error occurred running Audit.ListURI: %v", err)
+	}
+	testDeep(t, got, []string{"from-uri", "override"})
+}
+
+func TestCallOptionsTimeout(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.client.Timeout = 20 * time.Millisecond
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	url := client.getURL("audit/abc", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, `[{"Id": "1"}]`)
+	})
+
+	if _, _, err := client.Audit.List(context.Background(), "abc", false); err == nil {
+		t.Error("expected the client timeout to be hit")
+	}
+	_, records, err := client.Audit.List(context.Background(), "abc", false, WithCallTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("error occurred running Audit.List: %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records but want 1", len(records))
+	}
+	if _, _, err := client.Audit.List(context.Background(), "abc", false, WithCallTimeout(10*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v but want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCallOptionsTimeoutWholeCall(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	// every page is served within the timeout, but not all of them
+	url := client.getURL("subscriptions/content", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		page, _ := strconv.Atoi(r.URL.Query().Get("nextpage"))
+		if page < 3 {
+			next := *r.URL
+			query := next.Query()
+			query.Set("nextpage", strconv.Itoa(page+1))
+			next.RawQuery = query.Encode()
+			w.Header().Set("NextPageUri", "https://manage.office.com"+next.RequestURI())
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	ct := schema.AuditGeneral
+	_, _, err := client.Content.List(context.Background(), &ct, time.Time{}, time.Time{}, WithCallTimeout(70*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v but want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCallOptionsMaxResponseSize(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	body := `[{"Id": "1"}, {"Id": "2"}]`
+	url := client.getURL("audit/", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == url.Path+"chunked" {
+			// no Content-Length, the limit is enforced while reading
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, body)
+	})
+
+	for _, contentID := range []string{"sized", "chunked"} {
+		if _, _, err := client.Audit.List(context.Background(), contentID, false, WithMaxResponseSize(int64(len(body)-1))); !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("%s: got error %v but want %v", contentID, err, ErrResponseTooLarge)
+		}
+		_, records, err := client.Audit.List(context.Background(), contentID, false, WithMaxResponseSize(int64(len(body))))
+		if err != nil {
+			t.Fatalf("%s: error occurred running Audit.List: %v", contentID, err)
+		}
+		if len(records) != 2 {
+			t.Errorf("%s: got %d records but want 2", contentID, len(records))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package office365
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// error definition.
+var (
+	ErrCertificateMissing = errors.New("no certificate found")
+	ErrPrivateKeyMissing  = errors.New("no private key found")
+	ErrPrivateKeyMismatch = errors.New("private key does not match the certificate")
+	ErrPrivateKeyNotRSA   = errors.New("client assertions require a RSA private key")
+)
+
+// assertionLifetime is the validity of the client assertions generated.
+var assertionLifetime = 10 * time.Minute
+
+// ClientCertificate is a X.509 certificate along with its private key,
+// used to authenticate the application instead of a client secret.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/azure/active-directory/develop/active-directory-certificate-credentials
+type ClientCertificate struct {
+	Certificate *x509.Certificate
+	PrivateKey  crypto.Signer
+}
+
+// NewClientCertificate returns a ClientCertificate after checking that
+// the key matches the certificate.
+func NewClientCertificate(cert *x509.Certificate, key crypto.Signer) (*ClientCertificate, error) {
+	if cert == nil {
+		return nil, ErrCertificateMissing
+	}
+	if key == nil {
+		return nil, ErrPrivateKeyMissing
+	}
+	rsaKey, ok := key.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrPrivateKeyNotRSA
+	}
+	if !rsaKey.Equal(cert.PublicKey) {
+		return nil, ErrPrivateKeyMismatch
+	}
+	return &ClientCertificate{Certificate: cert, PrivateKey: key}, nil
+}
+
+// ParseCertificatePEM reads a certificate and its private key from PEM blocks.
+// Both can live in the same data, in which case keyPEM may be nil.
+// Unencrypted PKCS#1 and PKCS#8 keys are supported.
+func ParseCertificatePEM(certPEM, keyPEM []byte) (*ClientCertificate, error) {
+	var certs []*x509.Certificate
+	var key crypto.Signer
+	for _, data := range [][]byte{certPEM, keyPEM} {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			switch {
+			case block.Type == "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				certs = append(certs, cert)
+			case strings.HasSuffix(block.Type, "PRIVATE KEY") && key == nil:
+				k, err := parsePrivateKey(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				key = k
+			}
+		}
+	}
+	if key == nil {
+		return nil, ErrPrivateKeyMissing
+	}
+	return matchCertificate(certs, key)
+}
+
+// parsePrivateKey parses a DER encoded PKCS#1 or PKCS#8 private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("parsing private key: unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// matchCertificate returns a ClientCertificate made of the key and the
+// certificate holding its public key.
+func matchCertificate(certs []*x509.Certificate, key crypto.Signer) (*ClientCertificate, error) {
+	if len(certs) == 0 {
+		return nil, ErrCertificateMissing
+	}
+	for _, cert := range certs {
+		if c, err := NewClientCertificate(cert, key); err == nil {
+			return c, nil
+		}
+	}
+	return NewClientCertificate(certs[0], key)
+}
+
+// Thumbprint returns the base64url encoded SHA-1 thumbprint of the certificate,
+// as expected in the x5t header of client assertions.
+func (c *ClientCertificate) Thumbprint() string {
+	sum := sha1.Sum(c.Certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Assertion returns a signed JWT asserting the identity of clientID,
+// intended for the token endpoint audience.
+func (c *ClientCertificate) Assertion(clientID, audience string, now time.Time) (string, error) {
+	if _, ok := c.PrivateKey.Public().(*rsa.PublicKey); !ok {
+		return "", ErrPrivateKeyNotRSA
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	// the audience is the token endpoint, without the query string
+	if i := strings.IndexByte(audience, '?'); i >= 0 {
+		audience = audience[:i]
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": c.Thumbprint(),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": audience,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := c.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("signing client assertion: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,256 @@
+package office365
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func loadTestCertificate(t *testing.T) *ClientCertificate {
+	t.Helper()
+	certPEM, err := os.ReadFile("testdata/client.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := os.ReadFile("testdata/client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificatePEM(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestParsePKCS12(t *testing.T) {
+	want := loadTestCertificate(t)
+
+	cases := []struct {
+		File      string
+		Password  string
+		WantError error
+	}{
+		{File: "testdata/client.p12", Password: "test-password", WantError: nil},
+		{File: "testdata/client-legacy.p12", Password: "test-password", WantError: nil},
+		{File: "testdata/client.p12", Password: "wrong", WantError: ErrPKCS12Password},
+		{File: "testdata/client-legacy.p12", Password: "", WantError: ErrPKCS12Password},
+	}
+
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			data, err := os.ReadFile(c.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParsePKCS12(data, c.Password)
+			if !errors.Is(err, c.WantError) {
+				t.Fatalf("got error %v but want %v", err, c.WantError)
+			}
+			if err != nil {
+				return
+			}
+			if !got.Certificate.Equal(want.Certificate) {
+				t.Errorf("certificate does not match testdata/client.crt")
+			}
+			if got.Thumbprint() != want.Thumbprint() {
+				t.Errorf("got thumbprint %v but want %v", got.Thumbprint(), want.Thumbprint())
+			}
+		})
+	}
+}
+
+func TestParseCertificatePEM(t *testing.T) {
+	certPEM, _ := os.ReadFile("testdata/client.crt")
+	keyPEM, _ := os.ReadFile("testdata/client.key")
+
+	combined := append(append([]byte{}, certPEM...), keyPEM...)
+	if _, err := ParseCertificatePEM(combined, nil); err != nil {
+		t.Errorf("error parsing combined PEM: %v", err)
+	}
+	if _, err := ParseCertificatePEM(certPEM, nil); !errors.Is(err, ErrPrivateKeyMissing) {
+		t.Errorf("got error %v but want %v", err, ErrPrivateKeyMissing)
+	}
+	if _, err := ParseCertificatePEM(nil, keyPEM); !errors.Is(err, ErrCertificateMissing) {
+		t.Errorf("got error %v but want %v", err, ErrCertificateMissing)
+	}
+}
+
+// verifyAssertion checks the signature and claims of a client assertion.
+func verifyAssertion(t *testing.T, cert *ClientCertificate, assertion, clientID, audience string) {
+	t.Helper()
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed assertion: %s", assertion)
+	}
+
+	var header map[string]string
+	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "RS256" || header["x5t"] != cert.Thumbprint() {
+		t.Errorf("unexpected assertion header: %v", header)
+	}
+
+	var claims map[string]interface{}
+	data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != clientID || claims["sub"] != clientID || claims["aud"] != audience {
+		t.Errorf("unexpected assertion claims: %v", claims)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	pub := cert.Certificate.PublicKey.(*rsa.PublicKey)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("invalid assertion signature: %v", err)
+	}
+}
+
+func TestOAuthClientCertificate(t *testing.T) {
+	cert := loadTestCertificate(t)
+
+	var mu sync.Mutex
+	tokenRequests := 0
+	var authority *httptest.Server
+	authority = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokenRequests++
+		mu.Unlock()
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("error parsing token request: %s", err)
+		}
+		if got := r.PostForm.Get("client_assertion_type"); got != clientAssertionType {
+			t.Errorf("got client_assertion_type %v but want %v", got, clientAssertionType)
+		}
+		if r.PostForm.Get("client_secret") != "" {
+			t.Errorf("client_secret must not be sent")
+		}
+		verifyAssertion(t, cert, r.PostForm.Get("client_assertion"), "test-clientID", authority.URL+"/test-domain/oauth2/token")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "test-token", "token_type": "Bearer", "expires_in": "3600"}`)
+	}))
+	defer authority.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("got authorization %v but want Bearer test-token", got)
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	defer api.Close()
+
+	env := Environment{Name: "test", ManagementURL: api.URL, AuthorityURL: authority.URL, Resource: EnvironmentCommercial.Resource}
+	creds := &Credentials{
+		ClientID:     "test-clientID",
+		TenantDomain: "test-domain",
+		TenantID:     "test-tenantID",
+		Certificate:  cert,
+		Environment:  &env,
+	}
+	client := NewClientAuthenticated(creds, "")
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Subscription.List(context.Background()); err != nil {
+			t.Fatalf("error occurred running Subscriptions.List: %v", err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("got %d token requests but want 1", tokenRequests)
+	}
+}
+
+func TestOAuthClientCertificateRefresh(t *testing.T) {
+	// tokens expiring within the refresh window are renewed before every request
+	authority, requests := tokenServer(t, `"120"`)
+	var tokens []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		fmt.Fprint(w, `[]`)
+	}))
+	defer api.Close()
+
+	env := Environment{Name: "test", ManagementURL: api.URL, AuthorityURL: authority.URL, Resource: EnvironmentCommercial.Resource}
+	creds := &Credentials{
+		ClientID:     "test-clientID",
+		TenantDomain: "test-domain",
+		TenantID:     "test-tenantID",
+		Certificate:  loadTestCertificate(t),
+		Environment:  &env,
+	}
+	client := NewClientAuthenticated(creds, "")
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Subscription.List(context.Background()); err != nil {
+			t.Fatalf("error occurred running Subscriptions.List: %v", err)
+		}
+	}
+	if len(*requests) != 3 {
+		t.Errorf("got %d token requests but want 3", len(*requests))
+	}
+	testDeep(t, tokens, []string{"Bearer token-1", "Bearer token-2", "Bearer token-3"})
+}
+
+type countingTokenSource struct {
+	calls  int
+	expiry time.Duration
+	err    error
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", s.calls), Expiry: time.Now().Add(s.expiry)}, nil
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	src := &countingTokenSource{expiry: time.Hour}
+	ts := newCachingTokenSource(src, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.calls != 1 {
+		t.Errorf("got %d calls but want 1", src.calls)
+	}
+
+	// tokens expiring within the refresh window are renewed proactively
+	src = &countingTokenSource{expiry: 30 * time.Second}
+	ts = newCachingTokenSource(src, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.calls != 3 {
+		t.Errorf("got %d calls but want 3", src.calls)
+	}
+
+	// a still valid token is used if renewing fails
+	src.err = errors.New("authority unavailable")
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatalf("got error %v but want cached token", err)
+	}
+	if token.AccessToken != "token-3" {
+		t.Errorf("got token %v but want token-3", token.AccessToken)
+	}
+}
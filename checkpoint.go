@@ -0,0 +1,237 @@
+package office365
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// Ack acknowledges the record once the handler committed it, e.g. wrote it
+// to durable storage.
+// When the watcher runs with AtLeastOnce, State only advances past a content
+// blob once every record of it, and of the blobs listed before it, is
+// acknowledged. Records of a batch are acknowledged by calling Ack on each.
+// A blob whose records are not all acknowledged within AckTimeoutSeconds is
+// emitted again, along with the records already acknowledged unless the watcher
+// deduplicates them.
+// Ack is a no-op otherwise, and may be called more than once.
+func (r ResourceAudits) Ack() {
+	if r.ack != nil {
+		r.ack()
+	}
+}
+
+// checkpoint advances the State of a content type as the content listed
+// by a watcher is done, in the order it was listed.
+// A content blob is done once sealed and all its records are acknowledged,
+// a listed time window once all the blobs listed in it are done.
+type checkpoint struct {
+	ctx    context.Context
+	ct     schema.ContentType
+	state  State
+	logger *slog.Logger
+	// onDone is called with the ID of every blob done, if not nil.
+	onDone func(ctx context.Context, id string)
+	// onRelease is called with the ID of every blob released, if not nil.
+	onRelease func(id string)
+
+	mu    sync.Mutex
+	queue []*checkpointEntry
+	blobs map[string]*checkpointEntry
+	// released holds the blobs which failed or timed out, until they are listed again.
+	released map[string]*checkpointEntry
+}
+
+// checkpointEntry is a content blob, or the end of a time window if id is empty.
+type checkpointEntry struct {
+	id      string
+	created time.Time
+	end     time.Time
+	sealed  bool
+	skipped bool
+	pending int
+	// emitted is when the blob was sealed.
+	emitted time.Time
+	// resent is set once the blob is listed again after being released.
+	resent bool
+	// gen is incremented on release, so that the records sent before are no longer counted.
+	gen int
+}
+
+// newCheckpoint returns the checkpoint of a content type, updating s with
+// the values of ctx even once it is canceled, as records may be acknowledged later.
+func newCheckpoint(ctx context.Context, ct schema.ContentType, s State, l *slog.Logger) *checkpoint {
+	return &checkpoint{
+		ctx:      context.WithoutCancel(ctx),
+		ct:       ct,
+		state:    s,
+		logger:   loggerOrDefault(l).With("content-type", ct.String()),
+		blobs:    make(map[string]*checkpointEntry),
+		released: make(map[string]*checkpointEntry),
+	}
+}
+
+// addBlob queues a listed content blob, false if it is already queued.
+// A released blob takes its place in the queue back.
+func (c *checkpoint) addBlob(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.blobs[id]; ok {
+		return false
+	}
+	if e, ok := c.released[id]; ok {
+		delete(c.released, id)
+		e.resent = true
+		c.blobs[id] = e
+		return true
+	}
+	e := &checkpointEntry{id: id}
+	c.blobs[id] = e
+	c.queue = append(c.queue, e)
+	return true
+}
+
+// addWindow queues the end of a listed time window.
+func (c *checkpoint) addWindow(end time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queue = append(c.queue, &checkpointEntry{end: end, sealed: true})
+	c.advance()
+}
+
+// track counts a record of the blob as pending, and returns the function acknowledging it.
+func (c *checkpoint) track(id string) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.blobs[id]
+	if !ok {
+		return func() {}
+	}
+	e.pending++
+
+	gen := e.gen
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if e.gen != gen {
+				return
+			}
+			e.pending--
+			c.advance()
+		})
+	}
+}
+
+// seal marks the blob as emitted entirely, or skipped.
+// A zero created time leaves lastContentCreated as is.
+func (c *checkpoint) seal(id string, created time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blobs[id]; ok {
+		e.created = created
+		e.sealed = true
+		e.emitted = time.Now()
+		c.advance()
+	}
+}
+
+// skip marks the blob as skipped, as a duplicate: it is sealed without onDone
+// being called, so that a blob listed over and over is eventually forgotten.
+func (c *checkpoint) skip(id string, created time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blobs[id]; ok {
+		e.created = created
+		e.sealed = true
+		e.skipped = true
+		c.advance()
+	}
+}
+
+// resent reports whether the blob is listed again after being released.
+func (c *checkpoint) resent(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.blobs[id]
+	return ok && e.resent
+}
+
+// release gives the blob up after its records could not be fetched.
+// It is not done, holding the State back, and is fetched again once listed again.
+func (c *checkpoint) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blobs[id]; ok {
+		c.releaseEntry(e)
+	}
+}
+
+// expire releases the blobs emitted before t whose records are not all acknowledged,
+// so that they are emitted again once listed again.
+func (c *checkpoint) expire(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.blobs {
+		if e.sealed && e.pending > 0 && e.emitted.Before(t) {
+			c.logger.Warn("checkpoint: records not acknowledged, content released", "content-id", e.id, "pending", e.pending)
+			c.releaseEntry(e)
+		}
+	}
+}
+
+// releaseEntry moves the blob to the released ones, forgetting its pending records.
+// c.mu must be held.
+func (c *checkpoint) releaseEntry(e *checkpointEntry) {
+	delete(c.blobs, e.id)
+	e.sealed = false
+	e.pending = 0
+	e.gen++
+	c.released[e.id] = e
+	if c.onRelease != nil {
+		c.onRelease(e.id)
+	}
+}
+
+// advance updates the State with the entries done at the head of the queue.
+// c.mu must be held.
+func (c *checkpoint) advance() {
+	for len(c.queue) > 0 {
+		e := c.queue[0]
+		if !e.sealed || e.pending > 0 {
+			return
+		}
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+
+		switch {
+		case e.id == "":
+			if err := setLastRequestTime(c.ctx, c.state, c.ct, e.end); err != nil {
+				c.logger.Error("checkpoint: could not set lastRequestTime", "error", err)
+			}
+		default:
+			delete(c.blobs, e.id)
+			if !e.created.IsZero() {
+				if err := setLastContentCreated(c.ctx, c.state, c.ct, e.created); err != nil {
+					c.logger.Error("checkpoint: could not set lastContentCreated", "content-id", e.id, "error", err)
+				}
+			}
+			if c.onDone != nil && !e.skipped {
+				c.onDone(c.ctx, e.id)
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package office365
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ct := schema.AuditGeneral
+	state := NewMemoryState()
+	cp := newCheckpoint(context.Background(), ct, state, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	now := time.Now()
+	end := now.Add(time.Minute)
+	if !cp.addBlob("a") || !cp.addBlob("b") || cp.addBlob("a") {
+		t.Fatal("blobs must be queued once")
+	}
+	cp.addWindow(end)
+
+	ack1, ack2 := cp.track("a"), cp.track("a")
+	cp.seal("a", now)
+	cp.seal("b", now.Add(time.Second))
+	ack1()
+	ack1()
+	if got := getCheckpoint(t, state, ct).LastContentCreated; !got.IsZero() {
+		t.Errorf("got lastContentCreated %s with a record pending", got)
+	}
+
+	ack2()
+	if got := getCheckpoint(t, state, ct).LastContentCreated; !got.Equal(now.Add(time.Second)) {
+		t.Errorf("got lastContentCreated %s but want %s", got, now.Add(time.Second))
+	}
+	if got := getCheckpoint(t, state, ct).LastRequestTime; !got.Equal(end) {
+		t.Errorf("got lastRequestTime %s but want %s", got, end)
+	}
+	if !cp.addBlob("a") {
+		t.Error("done blobs must be released")
+	}
+}
+
+func TestCheckpointSkip(t *testing.T) {
+	ct := schema.AuditGeneral
+	cp := newCheckpoint(context.Background(), ct, NewMemoryState(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var done []string
+	cp.onDone = func(_ context.Context, id string) { done = append(done, id) }
+
+	cp.addBlob("a")
+	cp.addBlob("b")
+	cp.skip("a", time.Now())
+	cp.seal("b", time.Now())
+	testDeep(t, done, []string{"b"})
+}
+
+// killHandler acknowledges records until stop returns true, then cancels the watcher.
+type killHandler struct {
+	stop   func(id string) bool
+	cancel context.CancelFunc
+}
+
+func (h killHandler) Handle(in <-chan ResourceAudits) error {
+	for res := range in {
+		if h.stop(*res.AuditRecord.(schema.AuditRecord).ID) {
+			h.cancel()
+			break
+		}
+		res.Ack()
+	}
+	for range in {
+	}
+	return nil
+}
+
+func TestSubscriptionWatcherAtLeastOnce(t *testing.T) {
+	server, client := newTestServer(t)
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	server.StartSubscription("tenant", ct)
+	for i, blob := range []string{"1", "2", "3"} {
+		addRecords(t, server, ct, now.Add(time.Duration(i-3)*10*time.Minute), blob+"a", blob+"b")
+	}
+
+	conf := SubscriptionWatcherConfig{
+		LookBehindMinutes:     60,
+		TickerIntervalSeconds: 60,
+		AtLeastOnce:           true,
+		ContentTypes:          map[schema.ContentType]ContentTypeConfig{ct: {}},
+	}
+	run := func(state State, stop func(id string) bool) []string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var got []string
+		handler := killHandler{cancel: cancel, stop: func(id string) bool {
+			got = append(got, id)
+			return stop(id)
+		}}
+		_, wait := runTestWatcher(ctx, t, client, conf, state, handler)
+		if err := wait(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// the pipeline is killed in the middle of the second blob
+	state := NewMemoryState()
+	got := run(state, func(id string) bool { return id == "2a" })
+	testDeep(t, got, []string{"1a", "1b", "2a"})
+	if last := getCheckpoint(t, state, ct).LastContentCreated; last.IsZero() {
+		t.Error("lastContentCreated did not advance past the acknowledged blob")
+	}
+	if last := getCheckpoint(t, state, ct).LastRequestTime; !last.IsZero() {
+		t.Errorf("got lastRequestTime %s while blobs of the window are not acknowledged", last)
+	}
+
+	// the state is persisted and restored, as after a crash
+	got = run(restartState(t, state), func(id string) bool { return id == "3b" })
+	testDeep(t, got, []string{"2a", "2b", "3a", "3b"})
+}
+
+func TestSubscriptionWatcherAtLeastOnceFetchError(t *testing.T) {
+	server, client := newTestServer(t)
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	server.StartSubscription("tenant", ct)
+	for i, blob := range []string{"1", "2"} {
+		addRecords(t, server, ct, now.Add(time.Duration(i-3)*10*time.Minute), blob)
+	}
+	// the first blob fails once
+	server.Inject(office365test.Fault{Operation: office365test.OperationAuditFetch, StatusCode: 500, Code: "AF50000", Times: 1})
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 1, AtLeastOnce: true}
+	state := NewMemoryState()
+	runTestWatcher(context.Background(), t, client, conf, state, handler)
+
+	receive := func(want string) {
+		t.Helper()
+		res := receiveRecords(t, handler.ch, 1)[0]
+		if id := auditRecordID(res.AuditRecord); id != want {
+			t.Fatalf("got record %s but want %s", id, want)
+		}
+		res.Ack()
+	}
+
+	receive("2")
+	if got := getCheckpoint(t, state, ct); !got.LastContentCreated.IsZero() || !got.LastRequestTime.IsZero() {
+		t.Errorf("got checkpoint %+v past the blob which failed", got)
+	}
+
+	// the blob is listed and fetched again on the next tick
+	receive("1")
+	if got := getCheckpoint(t, state, ct); got.LastContentCreated.IsZero() || got.LastRequestTime.IsZero() {
+		t.Errorf("got checkpoint %+v while every blob is acknowledged", got)
+	}
+}
+
+func TestCheckpointExpire(t *testing.T) {
+	ct := schema.AuditGeneral
+	state := NewMemoryState()
+	cp := newCheckpoint(context.Background(), ct, state, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var released []string
+	cp.onRelease = func(id string) { released = append(released, id) }
+
+	now := time.Now()
+	cp.addBlob("a")
+	ack := cp.track("a")
+	cp.seal("a", now)
+	cp.expire(time.Now().Add(-time.Minute))
+	if len(released) != 0 {
+		t.Fatal("blobs must not be released before the timeout")
+	}
+	cp.expire(time.Now().Add(time.Minute))
+	testDeep(t, released, []string{"a"})
+
+	// the records sent before the release are no longer counted
+	if !cp.addBlob("a") || !cp.resent("a") {
+		t.Fatal("released blobs must be queued again")
+	}
+	ack()
+	cp.track("a")
+	cp.seal("a", now)
+	if got := getCheckpoint(t, state, ct).LastContentCreated; !got.IsZero() {
+		t.Errorf("got lastContentCreated %s with a record pending", got)
+	}
+}
+
+func TestSubscriptionWatcherAtLeastOnceAckTimeout(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		t.Run(fmt.Sprintf("deduplicate=%t", dedup), func(t *testing.T) {
+			server, client := newTestServer(t)
+
+			ct := schema.AuditGeneral
+			now := time.Now()
+			server.StartSubscription("tenant", ct)
+			addRecords(t, server, ct, now.Add(-30*time.Minute), "1a", "1b")
+			addRecords(t, server, ct, now.Add(-20*time.Minute), "2a")
+
+			handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+			conf := SubscriptionWatcherConfig{
+				LookBehindMinutes:     60,
+				TickerIntervalSeconds: 1,
+				AtLeastOnce:           true,
+				AckTimeoutSeconds:     1,
+				Deduplicate:           dedup,
+			}
+			state := NewMemoryState()
+			runTestWatcher(context.Background(), t, client, conf, state, handler)
+
+			// the handler drops 1b
+			for _, res := range receiveRecords(t, handler.ch, 3) {
+				if auditRecordID(res.AuditRecord) != "1b" {
+					res.Ack()
+				}
+			}
+			if got := getCheckpoint(t, state, ct); !got.LastContentCreated.IsZero() {
+				t.Errorf("got checkpoint %+v past the blob not acknowledged", got)
+			}
+
+			// the blob is emitted again once the timeout passed
+			want := []string{"1a", "1b"}
+			if dedup {
+				want = []string{"1b"}
+			}
+			received := receiveRecords(t, handler.ch, len(want))
+			var got []string
+			for _, res := range received {
+				got = append(got, auditRecordID(res.AuditRecord))
+				res.Ack()
+			}
+			testDeep(t, got, want)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for getCheckpoint(t, state, ct).LastRequestTime.IsZero() {
+				if time.Now().After(deadline) {
+					t.Fatalf("got checkpoint %+v while every blob is acknowledged", getCheckpoint(t, state, ct))
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
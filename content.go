@@ -2,7 +2,9 @@ package office365
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -12,7 +14,20 @@ import (
 // ContentService .
 type ContentService service
 
+// ErrStopPaging can be returned by a ListPages callback to stop paging early.
+var ErrStopPaging = errors.New("stop paging")
+
+// ContentPage is a page of content yielded by ListPages.
+type ContentPage struct {
+	Response *Response
+	Content  []Content
+	// NextPage is the opaque token of the next page, empty on the last page.
+	// It can be provided to ListPages for resuming from that page.
+	NextPage string
+}
+
 // List returns a list of content available for retrieval.
+// It follows every page, see ListPages for processing one page at a time.
 //
 // Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#list-available-content
 //
@@ -20,47 +35,99 @@ type ContentService service
 // The content is an aggregation of actions and events harvested from multiple servers across multiple datacenters.
 // The content will be listed in the order in which the aggregations become available, but the events and actions within
 // the aggregations are not guaranteed to be sequential. An error is returned if the subscription status is disabled.
-func (s *ContentService) List(ctx context.Context, ct *schema.ContentType, startTime time.Time, endTime time.Time) ([]*Response, []Content, error) {
+func (s *ContentService) List(ctx context.Context, ct *schema.ContentType, startTime time.Time, endTime time.Time, opts ...CallOption) ([]*Response, []Content, error) {
+	out := []Content{}
+	responses := []*Response{}
+	err := s.ListPages(ctx, ct, startTime, endTime, "", func(page *ContentPage) error {
+		responses = append(responses, page.Response)
+		out = append(out, page.Content...)
+		return nil
+	}, opts...)
+	if err != nil {
+		return responses, nil, err
+	}
+	return responses, out, nil
+}
+
+// ListPages lists the content available for retrieval one page at a time,
+// calling fn for every page.
+// Paging starts from the page identified by nextPage, or from the first page if empty.
+// The startTime and endTime must be the ones used when the nextPage token was obtained.
+// Paging stops at the first error returned by fn, which is returned by ListPages
+// unless it is ErrStopPaging.
+func (s *ContentService) ListPages(ctx context.Context, ct *schema.ContentType, startTime time.Time, endTime time.Time, nextPage string, fn func(*ContentPage) error, opts ...CallOption) error {
+	settings := s.client.newCallSettings(opts)
 	params := NewQueryParams()
-	params.AddPubIdentifier(s.client.pubIdentifier)
+	params.AddPubIdentifier(settings.pubIdentifier)
 	if err := params.AddContentType(ct); err != nil {
-		return nil, nil, err
+		return err
 	}
 	if err := params.AddStartEndTime(startTime, endTime); err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	out := []Content{}
-	responses := []*Response{}
+	ctx, cancel := withCallSettings(ctx, settings)
+	defer cancel()
+	ctx, span := s.client.startOperation(ctx, OperationContentList, ct)
+	response, pages, err := s.listPages(ctx, params, settings, nextPage, fn)
+	span.end(ctx, response, err, attrPageCount.Int(pages))
+	return err
+}
+
+// listPages sends the paging requests for ListPages.
+// It returns the last response received and the number of pages yielded.
+func (s *ContentService) listPages(ctx context.Context, params *QueryParams, settings *callSettings, nextPage string, fn func(*ContentPage) error) (*Response, int, error) {
+	var pages int
 	for {
+		if nextPage != "" {
+			params.Set("nextpage", nextPage)
+		}
 		req, err := s.client.newRequest("GET", "subscriptions/content", params.Values, nil)
 		if err != nil {
-			return responses, nil, err
+			return nil, pages, err
 		}
+		settings.apply(req)
 
 		var sub []Content
 		response, err := s.client.do(ctx, req, &sub)
 		if err != nil {
-			return responses, nil, err
-		}
-		responses = append(responses, response)
-		out = append(out, sub...)
-
-		nextPageURIStr := response.Response.Header.Get("NextPageUri")
-		if nextPageURIStr == "" {
-			break
+			return response, pages, err
 		}
-		nextPageURI, err := url.ParseRequestURI(nextPageURIStr)
+		nextPage, err = nextPageToken(response.Response.Header)
 		if err != nil {
-			return responses, nil, err
+			return response, pages, err
+		}
+
+		pages++
+		page := &ContentPage{Response: response, Content: sub, NextPage: nextPage}
+		if err := fn(page); err != nil {
+			if errors.Is(err, ErrStopPaging) {
+				return response, pages, nil
+			}
+			return response, pages, err
 		}
-		nextPage := nextPageURI.Query().Get("nextpage")
 		if nextPage == "" {
-			return responses, nil, fmt.Errorf("nextpage is not present as queryParam of NextPageUri header")
+			return response, pages, nil
 		}
-		params.Set("nextpage", nextPage)
 	}
-	return responses, out, nil
+}
+
+// nextPageToken returns the nextpage token found in the NextPageUri header,
+// or an empty string on the last page.
+func nextPageToken(h http.Header) (string, error) {
+	nextPageURIStr := h.Get("NextPageUri")
+	if nextPageURIStr == "" {
+		return "", nil
+	}
+	nextPageURI, err := url.ParseRequestURI(nextPageURIStr)
+	if err != nil {
+		return "", err
+	}
+	nextPage := nextPageURI.Query().Get("nextpage")
+	if nextPage == "" {
+		return "", fmt.Errorf("nextpage is not present as queryParam of NextPageUri header")
+	}
+	return nextPage, nil
 }
 
 // Content represents metadata needed for retreiving aggregated data.
@@ -70,4 +137,8 @@ type Content struct {
 	ContentURI        string `json:"contentUri"`
 	ContentCreated    string `json:"contentCreated"`
 	ContentExpiration string `json:"contentExpiration"`
+
+	// TenantID and ClientID are only set by webhook notifications.
+	TenantID string `json:"tenantId,omitempty"`
+	ClientID string `json:"clientId,omitempty"`
 }
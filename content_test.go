@@ -248,3 +248,80 @@ func TestContent(t *testing.T) {
 		})
 	}
 }
+
+func TestContentPages(t *testing.T) {
+
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	var store []Content
+	for i := 0; i < 5; i++ {
+		store = append(store, Content{ContentType: schema.AuditGeneral.String(), ContentID: strconv.Itoa(i)})
+	}
+
+	url := client.getURL("subscriptions/content", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		EnforceMethod(t, r, "GET")
+		EnforceAndReturnContentType(t, r)
+
+		pageSize := 2
+		index, _ := strconv.Atoi(r.URL.Query().Get("nextpage"))
+		last := index + pageSize
+		if last < len(store) {
+			nextPageURI, _ := url.Parse(r.URL.String())
+			queryParams := nextPageURI.Query()
+			queryParams.Set("nextpage", strconv.Itoa(last))
+			nextPageURI.RawQuery = queryParams.Encode()
+			w.Header().Set("NextPageUri", nextPageURI.String())
+		} else {
+			last = len(store)
+		}
+		if err := json.NewEncoder(w).Encode(store[index:last]); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	ct := schema.AuditGeneral
+	collect := func(nextPage string, maxPages int) ([]Content, string) {
+		t.Helper()
+		var contents []Content
+		var token string
+		pages := 0
+		err := client.Content.ListPages(context.Background(), &ct, time.Time{}, time.Time{}, nextPage, func(page *ContentPage) error {
+			contents = append(contents, page.Content...)
+			token = page.NextPage
+			pages++
+			if pages == maxPages {
+				return ErrStopPaging
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("error occurred running Content.ListPages: %v", err)
+		}
+		return contents, token
+	}
+
+	contents, token := collect("", 0)
+	testDeep(t, contents, store)
+	if token != "" {
+		t.Errorf("got token %q on last page", token)
+	}
+
+	// stop after the first page, then resume from its token
+	contents, token = collect("", 1)
+	testDeep(t, contents, store[0:2])
+	if token != "2" {
+		t.Errorf("got token %q but want 2", token)
+	}
+	contents, _ = collect(token, 0)
+	testDeep(t, contents, store[2:])
+
+	wantErr := fmt.Errorf("handler failure")
+	err := client.Content.ListPages(context.Background(), &ct, time.Time{}, time.Time{}, "", func(page *ContentPage) error {
+		return wantErr
+	})
+	if err != wantErr {
+		t.Errorf("got error %v but want %v", err, wantErr)
+	}
+}
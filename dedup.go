@@ -0,0 +1,146 @@
+package office365
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var defaultDedupTTLMinutes = 24 * 60
+
+// Duplicates returns the number of content blobs and of audit records skipped
+// as duplicates, see SubscriptionWatcherConfig.Deduplicate.
+func (s *SubscriptionWatcher) Duplicates() (content, records int64) {
+	return s.duplicateContent.Load(), s.duplicateRecords.Load()
+}
+
+// dedupExpiration returns the expiration of the IDs remembered now.
+func (s *SubscriptionWatcher) dedupExpiration() time.Time {
+	return time.Now().Add(time.Duration(s.config.DedupTTLMinutes) * time.Minute)
+}
+
+// setContentDone remembers a content blob whose records were all emitted, or acknowledged.
+func (s *SubscriptionWatcher) setContentDone(ctx context.Context, id string) {
+	if err := s.dedup.SetContentSeen(ctx, id, s.dedupExpiration()); err != nil {
+		s.logger.Error("dedup: could not set content seen", "content-id", id, "error", err)
+	}
+}
+
+// isDuplicateContent reports whether the content blob was already emitted, counting it if so.
+// Content is not a duplicate if State fails, so that it is not lost.
+func (s *SubscriptionWatcher) isDuplicateContent(ctx context.Context, res ResourceContent) bool {
+	seen, err := s.dedup.ContentSeen(ctx, res.Content.ContentID)
+	if err != nil {
+		s.logger.Error("dedup: could not get content seen", "content-id", res.Content.ContentID, "error", err)
+	}
+	if !seen {
+		return false
+	}
+	s.duplicateContent.Add(1)
+	s.client.telemetry.duplicates.Add(ctx, 1, metric.WithAttributes(
+		attrContentType.String(res.ContentType.String()), attrDuplicate.String("content")))
+	return true
+}
+
+// isDuplicateRecord reports whether the audit record was already emitted, or is being
+// emitted, counting it if so. Otherwise the record of the content blob contentID is marked
+// as in flight until acknowledged, or until the blob is released.
+// Records without an ID are never duplicates, nor are they if State fails.
+func (s *SubscriptionWatcher) isDuplicateRecord(ctx context.Context, a ResourceAudits, contentID string) bool {
+	id := auditRecordID(a.AuditRecord)
+	if id == "" {
+		return false
+	}
+
+	s.muRecords.Lock()
+	_, seen := s.inflightRecords[id]
+	if !seen {
+		var err error
+		seen, err = s.dedup.RecordSeen(ctx, id)
+		if err != nil {
+			s.logger.Error("dedup: could not get record seen", "record-id", id, "error", err)
+		}
+	}
+	if !seen {
+		s.inflightRecords[id] = contentID
+	}
+	s.muRecords.Unlock()
+
+	if !seen {
+		return false
+	}
+	s.duplicateRecords.Add(1)
+	s.client.telemetry.duplicates.Add(ctx, 1, metric.WithAttributes(
+		attrContentType.String(a.ContentType.String()), attrDuplicate.String("record")))
+	return true
+}
+
+// recordAck returns the function acknowledging the record, which calls ack if not nil
+// and, when deduplicating, remembers the record.
+// The values of ctx are used even once it is canceled.
+func (s *SubscriptionWatcher) recordAck(ctx context.Context, a ResourceAudits, ack func()) func() {
+	id := auditRecordID(a.AuditRecord)
+	if !s.config.Deduplicate || id == "" {
+		return ack
+	}
+	ctx = context.WithoutCancel(ctx)
+	return func() {
+		if err := s.dedup.SetRecordSeen(ctx, id, s.dedupExpiration()); err != nil {
+			s.logger.Error("dedup: could not set record seen", "record-id", id, "error", err)
+		}
+		s.releaseRecord(a)
+		if ack != nil {
+			ack()
+		}
+	}
+}
+
+// releaseRecord removes the audit record from the records in flight.
+func (s *SubscriptionWatcher) releaseRecord(a ResourceAudits) {
+	s.muRecords.Lock()
+	defer s.muRecords.Unlock()
+
+	delete(s.inflightRecords, auditRecordID(a.AuditRecord))
+}
+
+// releaseContentRecords removes the records of a released content blob from
+// the records in flight, so that they are emitted again with the blob.
+func (s *SubscriptionWatcher) releaseContentRecords(contentID string) {
+	s.muRecords.Lock()
+	defer s.muRecords.Unlock()
+
+	for id, c := range s.inflightRecords {
+		if c == contentID {
+			delete(s.inflightRecords, id)
+		}
+	}
+}
+
+// auditRecordID returns the Id of a decoded audit record, in its common or
+// extended schema, or an empty string if it has none.
+func auditRecordID(record interface{}) string {
+	switch r := record.(type) {
+	case schema.AuditRecord:
+		return stringValue(r.ID)
+	case *schema.AuditRecord:
+		if r == nil {
+			return ""
+		}
+		return stringValue(r.ID)
+	}
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName("AuditRecord")
+	if !f.IsValid() {
+		return ""
+	}
+	if r, ok := f.Interface().(schema.AuditRecord); ok {
+		return stringValue(r.ID)
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package office365
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestAuditRecordID(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Record interface{}
+		Want   string
+	}{
+		{"common", schema.AuditRecord{ID: String("a")}, "a"},
+		{"pointer", &schema.AuditRecord{ID: String("b")}, "b"},
+		{"extended", schema.ExchangeAdmin{AuditRecord: schema.AuditRecord{ID: String("c")}}, "c"},
+		{"no id", schema.AuditRecord{}, ""},
+		{"raw", map[string]interface{}{"Id": "d"}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := auditRecordID(tc.Record); got != tc.Want {
+				t.Errorf("got id %q but want %q", got, tc.Want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionWatcherDeduplicate(t *testing.T) {
+	server, client := newTestServer(t)
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	server.StartSubscription("tenant", ct)
+	addRecords(t, server, ct, now.Add(-30*time.Minute), "a", "b")
+	addRecords(t, server, ct, now.Add(-20*time.Minute), "b", "c")
+
+	conf := SubscriptionWatcherConfig{
+		LookBehindMinutes:     60,
+		TickerIntervalSeconds: 1,
+		Deduplicate:           true,
+		ContentTypes:          map[schema.ContentType]ContentTypeConfig{ct: {}},
+	}
+	// waitContent waits for the blobs to be listed again by overlapping windows.
+	waitContent := func(watcher *SubscriptionWatcher, n int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for content, _ := watcher.Duplicates(); content < n; content, _ = watcher.Duplicates() {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d duplicate blobs but want %d", content, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	state := NewMemoryState()
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, wait := runTestWatcher(ctx, t, client, conf, state, handler)
+	testDeep(t, receiveIDs(t, handler.ch, 3), []string{"a", "b", "c"})
+
+	// a blob listed late, created before the last content, is still fetched
+	addRecords(t, server, ct, now.Add(-40*time.Minute), "d", "a")
+	testDeep(t, receiveIDs(t, handler.ch, 1), []string{"d"})
+	waitContent(watcher, 3)
+	if _, records := watcher.Duplicates(); records != 2 {
+		t.Errorf("got %d duplicate records but want 2", records)
+	}
+	cancel()
+	_ = wait()
+
+	// the state is persisted and restored, as after a restart
+	handler = chanHandler{ch: make(chan ResourceAudits, 100)}
+	watcher, _ = runTestWatcher(context.Background(), t, client, conf, restartState(t, state), handler)
+	waitContent(watcher, 3)
+	select {
+	case res := <-handler.ch:
+		t.Errorf("got duplicate record %#v after a restart", res.AuditRecord)
+	default:
+	}
+}
+
+func TestSubscriptionWatcherDeduplicateInFlight(t *testing.T) {
+	server, client := newTestServer(t)
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	server.StartSubscription("tenant", ct)
+	addRecords(t, server, ct, now.Add(-30*time.Minute), "a", "b")
+	addRecords(t, server, ct, now.Add(-20*time.Minute), "b", "c")
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 60, AtLeastOnce: true, Deduplicate: true}
+	watcher, _ := runTestWatcher(context.Background(), t, client, conf, NewMemoryState(), handler)
+
+	// records are not acknowledged until every one is received
+	received := receiveRecords(t, handler.ch, 3)
+	var got []string
+	for _, res := range received {
+		got = append(got, auditRecordID(res.AuditRecord))
+	}
+	testDeep(t, got, []string{"a", "b", "c"})
+	if _, records := watcher.Duplicates(); records != 1 {
+		t.Errorf("got %d duplicate records but want 1", records)
+	}
+	for _, res := range received {
+		res.Ack()
+	}
+}
+
+func TestDedupStateRequired(t *testing.T) {
+	// checkpointState only implements State
+	type checkpointState struct{ State }
+	state := checkpointState{NewMemoryState()}
+
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5}
+	if _, err := NewSubscriptionWatcher(nil, conf, state, nil, nil); err != nil {
+		t.Errorf("got error %v without Deduplicate", err)
+	}
+	conf.Deduplicate = true
+	if _, err := NewSubscriptionWatcher(nil, conf, state, nil, nil); err == nil {
+		t.Error("Deduplicate must require a DedupState")
+	}
+	if _, err := NewSubscriptionWatcher(nil, conf, NewMemoryState(), nil, nil); err != nil {
+		t.Errorf("got error %v with a MemoryState", err)
+	}
+	conf.Deduplicate = false
+	if _, err := NewWebhookWatcher(nil, conf, nil, state, nil, nil); err == nil {
+		t.Error("WebhookWatcher must require a DedupState")
+	}
+}
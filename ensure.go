@@ -0,0 +1,200 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// DesiredSubscription is the state a subscription should be in, see SubscriptionService.EnsureSubscriptions.
+type DesiredSubscription struct {
+	// Enabled tells whether the subscription should be started or stopped.
+	Enabled bool
+	// Webhook is the webhook an enabled subscription should notify, none if nil.
+	// Its Status is ignored, and its Expiration is only compared when set.
+	Webhook *Webhook
+}
+
+// SubscriptionAction is a change applied to a subscription.
+type SubscriptionAction string
+
+// Actions taken by SubscriptionService.EnsureSubscriptions.
+const (
+	// SubscriptionActionStart starts a subscription which is missing or disabled.
+	SubscriptionActionStart SubscriptionAction = "start"
+	// SubscriptionActionUpdate starts an enabled subscription again for changing,
+	// re-enabling or removing its webhook.
+	SubscriptionActionUpdate SubscriptionAction = "update"
+	// SubscriptionActionStop stops an enabled subscription.
+	SubscriptionActionStop SubscriptionAction = "stop"
+)
+
+// SubscriptionChange is a change needed for a subscription to reach its desired state.
+type SubscriptionChange struct {
+	ContentType schema.ContentType
+	Action      SubscriptionAction
+	// Reason describes the drift, e.g. "webhook address differs".
+	Reason string
+	// Current is the subscription as listed, nil if not found.
+	Current *Subscription
+	Desired DesiredSubscription
+	// Applied tells whether the change was made, false on dry-run or failure.
+	Applied bool
+	// Err is the error that occurred applying the change, if any.
+	Err error
+}
+
+// SubscriptionReport lists the changes found by SubscriptionService.EnsureSubscriptions.
+type SubscriptionReport struct {
+	DryRun bool
+	// Changes are sorted by content type.
+	Changes []SubscriptionChange
+	// Unchanged lists the content types already in their desired state.
+	Unchanged []schema.ContentType
+}
+
+// Failed returns the changes that could not be applied.
+func (r *SubscriptionReport) Failed() []SubscriptionChange {
+	var failed []SubscriptionChange
+	for _, c := range r.Changes {
+		if c.Err != nil {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// EnsureSubscriptions brings the subscriptions of the content types in desired to their
+// desired state, starting, updating or stopping them as needed.
+// The subscriptions of other content types are left untouched.
+//
+// The changes are only listed if dryRun is true.
+// Otherwise a failing change does not prevent the others from being applied, and the
+// returned error joins the errors of all failed changes.
+// The report is nil only if the subscriptions could not be listed.
+func (s *SubscriptionService) EnsureSubscriptions(ctx context.Context, desired map[schema.ContentType]DesiredSubscription, dryRun bool, opts ...CallOption) (*SubscriptionReport, error) {
+	_, subscriptions, err := s.List(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[schema.ContentType]*Subscription, len(subscriptions))
+	for i := range subscriptions {
+		if subscriptions[i].ContentType == nil {
+			continue
+		}
+		ct, err := schema.GetContentType(*subscriptions[i].ContentType)
+		if err != nil {
+			continue
+		}
+		current[*ct] = &subscriptions[i]
+	}
+
+	contentTypes := make([]schema.ContentType, 0, len(desired))
+	for ct := range desired {
+		contentTypes = append(contentTypes, ct)
+	}
+	sort.Slice(contentTypes, func(i, j int) bool { return contentTypes[i].String() < contentTypes[j].String() })
+
+	report := &SubscriptionReport{DryRun: dryRun}
+	var errs []error
+	for _, ct := range contentTypes {
+		change, ok := diffSubscription(ct, current[ct], desired[ct])
+		if !ok {
+			report.Unchanged = append(report.Unchanged, ct)
+			continue
+		}
+		if !dryRun {
+			change.Err = s.applyChange(ctx, change, opts)
+			if change.Err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", change.Action, ct.String(), change.Err))
+			}
+			change.Applied = change.Err == nil
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	return report, errors.Join(errs...)
+}
+
+// diffSubscription returns the change needed for the subscription to reach its desired state,
+// and false if none is.
+func diffSubscription(ct schema.ContentType, current *Subscription, desired DesiredSubscription) (SubscriptionChange, bool) {
+	change := SubscriptionChange{ContentType: ct, Current: current, Desired: desired}
+	enabled := current != nil && current.Status != nil && strings.EqualFold(*current.Status, "enabled")
+
+	switch {
+	case !desired.Enabled && enabled:
+		change.Action, change.Reason = SubscriptionActionStop, "subscription is enabled"
+	case !desired.Enabled:
+		return change, false
+	case current == nil:
+		change.Action, change.Reason = SubscriptionActionStart, "subscription not found"
+	case !enabled:
+		change.Action, change.Reason = SubscriptionActionStart, "subscription is not enabled"
+	default:
+		reason := diffWebhook(current.Webhook, desired.Webhook)
+		if reason == "" {
+			return change, false
+		}
+		change.Action, change.Reason = SubscriptionActionUpdate, reason
+	}
+	return change, true
+}
+
+// diffWebhook describes how the current webhook differs from the desired one, empty if it does not.
+func diffWebhook(current, desired *Webhook) string {
+	switch {
+	case current == nil && desired == nil:
+		return ""
+	case current == nil:
+		return "webhook not found"
+	case desired == nil:
+		return "webhook should be removed"
+	case stringValue(current.Address) != stringValue(desired.Address):
+		return "webhook address differs"
+	case stringValue(current.AuthID) != stringValue(desired.AuthID):
+		return "webhook authId differs"
+	case desired.Expiration != nil && !sameWebhookExpiration(stringValue(current.Expiration), *desired.Expiration):
+		return "webhook expiration differs"
+	case current.Status != nil && !strings.EqualFold(*current.Status, "enabled"):
+		return fmt.Sprintf("webhook is %s", *current.Status)
+	}
+	return ""
+}
+
+// sameWebhookExpiration reports whether both expirations are the same time,
+// whatever their format, or the same string if one cannot be parsed.
+func sameWebhookExpiration(a, b string) bool {
+	ta, okA := parseWebhookExpiration(a)
+	tb, okB := parseWebhookExpiration(b)
+	if okA && okB {
+		return ta.Equal(tb)
+	}
+	return a == b
+}
+
+// applyChange makes the provided change.
+func (s *SubscriptionService) applyChange(ctx context.Context, change SubscriptionChange, opts []CallOption) error {
+	ct := change.ContentType
+	if change.Action == SubscriptionActionStop {
+		_, err := s.Stop(ctx, &ct, opts...)
+		return err
+	}
+
+	var body map[string]any
+	switch {
+	case change.Desired.Webhook != nil:
+		// the status is set by the API
+		webhook := *change.Desired.Webhook
+		webhook.Status = nil
+		body = map[string]any{"webhook": &webhook}
+	case change.Current != nil && change.Current.Webhook != nil:
+		// an explicit null removes the webhook
+		body = map[string]any{"webhook": nil}
+	}
+	_, _, err := s.start(ctx, &ct, body, opts)
+	return err
+}
@@ -0,0 +1,135 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestEnsureSubscriptions(t *testing.T) {
+	hook := httptest.NewServer(NewWebhookHandler("", nil))
+	defer hook.Close()
+
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+
+	server.StartSubscription("tenant", schema.AuditExchange)
+	server.StartSubscription("tenant", schema.AuditSharePoint)
+	server.StartSubscription("tenant", schema.DLPAll)
+	server.StartSubscription("tenant", schema.AuditAzureActiveDirectory)
+	server.StopSubscription("tenant", schema.AuditAzureActiveDirectory)
+	stale := schema.AuditSharePoint
+	if _, _, err := client.Subscription.Start(context.Background(), &stale, &Webhook{Address: String(hook.URL + "/stale")}); err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+
+	webhook := &Webhook{Address: String(hook.URL)}
+	desired := map[schema.ContentType]DesiredSubscription{
+		schema.AuditGeneral:              {Enabled: true, Webhook: webhook},
+		schema.AuditAzureActiveDirectory: {Enabled: true},
+		schema.AuditSharePoint:           {Enabled: true, Webhook: webhook},
+		schema.AuditExchange:             {Enabled: false},
+		schema.DLPAll:                    {Enabled: true},
+	}
+	wantChanges := map[schema.ContentType]SubscriptionAction{
+		schema.AuditGeneral:              SubscriptionActionStart,
+		schema.AuditAzureActiveDirectory: SubscriptionActionStart,
+		schema.AuditSharePoint:           SubscriptionActionUpdate,
+		schema.AuditExchange:             SubscriptionActionStop,
+	}
+	checkReport := func(report *SubscriptionReport, dryRun bool) {
+		t.Helper()
+		got := make(map[schema.ContentType]SubscriptionAction)
+		for _, c := range report.Changes {
+			got[c.ContentType] = c.Action
+			if c.Applied == dryRun {
+				t.Errorf("got change %s %s applied %v with dry-run %v", c.Action, c.ContentType.String(), c.Applied, dryRun)
+			}
+		}
+		testDeep(t, got, wantChanges)
+		testDeep(t, report.Unchanged, []schema.ContentType{schema.DLPAll})
+	}
+
+	report, err := client.Subscription.EnsureSubscriptions(context.Background(), desired, true)
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.EnsureSubscriptions: %v", err)
+	}
+	checkReport(report, true)
+	if got := server.Requests(office365test.OperationSubscriptionStart) + server.Requests(office365test.OperationSubscriptionStop); got != 1 {
+		t.Errorf("got %d start or stop requests on dry-run but want only the setup one", got)
+	}
+
+	report, err = client.Subscription.EnsureSubscriptions(context.Background(), desired, false)
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.EnsureSubscriptions: %v", err)
+	}
+	checkReport(report, false)
+
+	report, err = client.Subscription.EnsureSubscriptions(context.Background(), desired, false)
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.EnsureSubscriptions: %v", err)
+	}
+	if len(report.Changes) != 0 || len(report.Unchanged) != len(desired) {
+		t.Errorf("got changes %+v once subscriptions are in their desired state", report.Changes)
+	}
+}
+
+func TestEnsureSubscriptionsFailure(t *testing.T) {
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	server.Inject(office365test.Fault{Operation: office365test.OperationSubscriptionStart, StatusCode: 500, Code: "AF50000", Times: 1})
+	desired := map[schema.ContentType]DesiredSubscription{
+		schema.AuditExchange: {Enabled: true},
+		schema.AuditGeneral:  {Enabled: true},
+	}
+	report, err := client.Subscription.EnsureSubscriptions(context.Background(), desired, false)
+	if err == nil {
+		t.Fatal("expected an error for the failing change")
+	}
+	failed := report.Failed()
+	if len(failed) != 1 || !errors.Is(err, failed[0].Err) {
+		t.Fatalf("got failed changes %+v but want one matching %v", failed, err)
+	}
+	if len(report.Changes) != 2 || !report.Changes[0].Applied && !report.Changes[1].Applied {
+		t.Errorf("got changes %+v but want the other change applied", report.Changes)
+	}
+}
+
+func TestDiffWebhook(t *testing.T) {
+	address := "https://example.com/webhook"
+	cases := []struct {
+		Name    string
+		Current *Webhook
+		Desired *Webhook
+		Want    string
+	}{
+		{"none", nil, nil, ""},
+		{"missing", nil, &Webhook{Address: String(address)}, "webhook not found"},
+		{"removed", &Webhook{Address: String(address)}, nil, "webhook should be removed"},
+		{"same expiration", &Webhook{Address: String(address), Expiration: String("2030-01-02T03:04:05.000Z")},
+			&Webhook{Address: String(address), Expiration: String("2030-01-02T05:04:05+02:00")}, ""},
+		{"no expiration desired", &Webhook{Address: String(address), Expiration: String("2030-01-02T03:04:05")},
+			&Webhook{Address: String(address)}, ""},
+		{"later expiration", &Webhook{Address: String(address), Expiration: String("2030-01-02T03:04:05Z")},
+			&Webhook{Address: String(address), Expiration: String("2030-01-03T03:04:05Z")}, "webhook expiration differs"},
+		{"disabled", &Webhook{Address: String(address), Status: String("disabled")},
+			&Webhook{Address: String(address)}, "webhook is disabled"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := diffWebhook(tc.Current, tc.Desired); got != tc.Want {
+				t.Errorf("got %q but want %q", got, tc.Want)
+			}
+		})
+	}
+}
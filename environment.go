@@ -0,0 +1,101 @@
+package office365
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Environment describes a Microsoft cloud the Management Activity API is served from.
+// The management host, the token authority and the token resource must match,
+// so they are switched together.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#activity-api-operations
+type Environment struct {
+	// Name is a human readable identifier.
+	Name string
+	// ManagementURL is the base URL of the Management Activity API.
+	ManagementURL string
+	// AuthorityURL is the base URL of the Azure AD token authority.
+	AuthorityURL string
+	// Resource is the resource tokens are requested for.
+	Resource string
+}
+
+// Environments supported out of the box.
+var (
+	EnvironmentCommercial = Environment{
+		Name:          "commercial",
+		ManagementURL: "https://manage.office.com",
+		AuthorityURL:  "https://login.windows.net",
+		Resource:      "https://manage.office.com",
+	}
+	EnvironmentGCC = Environment{
+		Name:          "gcc",
+		ManagementURL: "https://manage-gcc.office.com",
+		AuthorityURL:  "https://login.microsoftonline.com",
+		Resource:      "https://manage-gcc.office.com",
+	}
+	EnvironmentGCCHigh = Environment{
+		Name:          "gcc-high",
+		ManagementURL: "https://manage.office365.us",
+		AuthorityURL:  "https://login.microsoftonline.us",
+		Resource:      "https://manage.office365.us",
+	}
+	EnvironmentDoD = Environment{
+		Name:          "dod",
+		ManagementURL: "https://manage.protection.apps.mil",
+		AuthorityURL:  "https://login.microsoftonline.us",
+		Resource:      "https://manage.protection.apps.mil",
+	}
+	EnvironmentChina = Environment{
+		Name:          "china",
+		ManagementURL: "https://manage.chinacloudapi.cn",
+		AuthorityURL:  "https://login.chinacloudapi.cn",
+		Resource:      "https://manage.chinacloudapi.cn",
+	}
+)
+
+var environments = map[string]Environment{
+	EnvironmentCommercial.Name: EnvironmentCommercial,
+	EnvironmentGCC.Name:        EnvironmentGCC,
+	EnvironmentGCCHigh.Name:    EnvironmentGCCHigh,
+	EnvironmentDoD.Name:        EnvironmentDoD,
+	EnvironmentChina.Name:      EnvironmentChina,
+	"21vianet":                 EnvironmentChina,
+}
+
+// GetEnvironment returns the Environment registered under the provided name.
+func GetEnvironment(name string) (*Environment, error) {
+	if env, ok := environments[strings.ToLower(name)]; ok {
+		return &env, nil
+	}
+	return nil, fmt.Errorf("environment %q is unknown", name)
+}
+
+// TokenURL returns the legacy token endpoint of the environment for the provided tenant.
+func (e Environment) TokenURL(tenant string) string {
+	return fmt.Sprintf("%s/%s/oauth2/token?api-version=1.0", strings.TrimSuffix(e.AuthorityURL, "/"), tenant)
+}
+
+// environmentOrDefault returns the provided Environment,
+// or EnvironmentCommercial if nil.
+func environmentOrDefault(env *Environment) Environment {
+	if env == nil {
+		return EnvironmentCommercial
+	}
+	return *env
+}
+
+// ClientOption configures a Client created with NewClient.
+type ClientOption func(*Client)
+
+// WithEnvironment makes the client query the management host of the provided Environment.
+// The BaseURL is left untouched if ManagementURL cannot be parsed.
+func WithEnvironment(env Environment) ClientOption {
+	return func(c *Client) {
+		if baseURL, err := url.Parse(env.ManagementURL); err == nil {
+			c.BaseURL = baseURL
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package office365
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetEnvironment(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Want      *Environment
+		WantError error
+	}{
+		{Name: "commercial", Want: &EnvironmentCommercial},
+		{Name: "GCC-High", Want: &EnvironmentGCCHigh},
+		{Name: "dod", Want: &EnvironmentDoD},
+		{Name: "21Vianet", Want: &EnvironmentChina},
+		{Name: "mars", Want: nil, WantError: fmt.Errorf("environment %q is unknown", "mars")},
+	}
+
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			env, err := GetEnvironment(c.Name)
+			testError(t, c.Want, c.WantError, err)
+			testDeep(t, env, c.Want)
+		})
+	}
+}
+
+func TestClientEnvironment(t *testing.T) {
+	client := NewClient(nil, "test-tenantID", "", WithEnvironment(EnvironmentGCCHigh))
+	if got := client.BaseURL.String(); got != EnvironmentGCCHigh.ManagementURL {
+		t.Errorf("got baseURL %v but want %v", got, EnvironmentGCCHigh.ManagementURL)
+	}
+
+	wantURL := "https://manage.office365.us/api/v1.0/test-tenantID/activity/feed/subscriptions/list"
+	if got := client.getURL("subscriptions/list", nil).String(); got != wantURL {
+		t.Errorf("got url %v but want %v", got, wantURL)
+	}
+}
+
+func TestOAuthClientEnvironment(t *testing.T) {
+	var gotPath, gotResource string
+	authority := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("error parsing token request: %s", err)
+		}
+		gotPath = r.URL.Path
+		gotResource = r.PostForm.Get("resource")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "test-token", "token_type": "Bearer", "expires_in": "3600"}`)
+	}))
+	defer authority.Close()
+
+	var gotAuthorization string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthorization = r.Header.Get("Authorization")
+		fmt.Fprint(w, `[]`)
+	}))
+	defer api.Close()
+
+	env := Environment{
+		Name:          "test",
+		ManagementURL: api.URL,
+		AuthorityURL:  authority.URL,
+		Resource:      "https://manage.office365.us",
+	}
+	creds := &Credentials{
+		ClientID:     "test-clientID",
+		ClientSecret: "test-secret",
+		TenantDomain: "test-domain",
+		TenantID:     "test-tenantID",
+		Environment:  &env,
+	}
+	client := NewClientAuthenticated(creds, "")
+	if _, _, err := client.Subscription.List(context.Background()); err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+
+	if gotPath != "/test-domain/oauth2/token" {
+		t.Errorf("got token path %v but want /test-domain/oauth2/token", gotPath)
+	}
+	if gotResource != env.Resource {
+		t.Errorf("got resource %v but want %v", gotResource, env.Resource)
+	}
+	if gotAuthorization != "Bearer test-token" {
+		t.Errorf("got authorization %v but want Bearer test-token", gotAuthorization)
+	}
+}
@@ -0,0 +1,80 @@
+package office365
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Management Activity API errors.
+// An *ErrorResponse matches them with errors.Is, based on the error code
+// returned in its body or on its status code.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#errors
+var (
+	ErrPermissionDenied        = errors.New("permission set in the token is invalid")
+	ErrTenantNotProvisioned    = errors.New("tenant does not exist or is not provisioned")
+	ErrTenantMismatch          = errors.New("tenant does not match the access token")
+	ErrInvalidTenant           = errors.New("tenant is not a valid GUID")
+	ErrInvalidContentType      = errors.New("invalid content type")
+	ErrWebhookValidationFailed = errors.New("webhook endpoint could not be validated")
+	ErrSubscriptionNotEnabled  = errors.New("subscription is not enabled for the content type")
+	ErrInvalidTimeWindow       = errors.New("invalid start time and end time")
+	ErrInvalidNextPage         = errors.New("invalid nextpage")
+	ErrContentNotFound         = errors.New("content does not exist")
+	ErrContentExpired          = errors.New("content has expired")
+	ErrThrottled               = errors.New("too many requests")
+	ErrInternal                = errors.New("internal server error")
+)
+
+// apiErrorCodes maps the error codes returned by the API to their sentinel error.
+var apiErrorCodes = map[string]error{
+	"AF10001": ErrPermissionDenied,
+	"AF20010": ErrTenantMismatch,
+	"AF20011": ErrTenantNotProvisioned,
+	"AF20012": ErrTenantNotProvisioned,
+	"AF20013": ErrInvalidTenant,
+	"AF20020": ErrInvalidContentType,
+	"AF20053": ErrInvalidContentType,
+	"AF20021": ErrWebhookValidationFailed,
+	"AF20022": ErrSubscriptionNotEnabled,
+	"AF20023": ErrSubscriptionNotEnabled,
+	"AF20030": ErrInvalidTimeWindow,
+	"AF20054": ErrInvalidTimeWindow,
+	"AF20055": ErrInvalidTimeWindow,
+	"AF20056": ErrInvalidTimeWindow,
+	"AF20031": ErrInvalidNextPage,
+	"AF20050": ErrContentNotFound,
+	"AF20052": ErrContentNotFound,
+	"AF20051": ErrContentExpired,
+	"AF429":   ErrThrottled,
+	"AF50000": ErrInternal,
+}
+
+// apiStatusCodes maps http status codes to their sentinel error.
+var apiStatusCodes = map[int]error{
+	http.StatusBadRequest:      ErrBadRequest,
+	http.StatusNotFound:        ErrNotFound,
+	http.StatusTooManyRequests: ErrThrottled,
+}
+
+// Code returns the error code found in the response body, if any.
+func (r *ErrorResponse) Code() string {
+	if r.Err == nil {
+		return ""
+	}
+	return r.Err.Error.Code
+}
+
+// Is reports whether the response matches the target sentinel error,
+// either through its error code or its status code.
+func (r *ErrorResponse) Is(target error) bool {
+	if err, ok := apiErrorCodes[r.Code()]; ok && err == target {
+		return true
+	}
+	if r.Response != nil {
+		if err, ok := apiStatusCodes[r.Response.StatusCode]; ok && err == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestErrorResponseIs(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	var status int
+	var body string
+	url := client.getURL("subscriptions/content", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	})
+
+	cases := []struct {
+		Status  int
+		Code    string
+		Want    []error
+		WantNot []error
+	}{
+		{
+			Status:  http.StatusBadRequest,
+			Code:    "AF20022",
+			Want:    []error{ErrSubscriptionNotEnabled, ErrBadRequest},
+			WantNot: []error{ErrNotFound, ErrContentExpired},
+		},
+		{
+			Status:  http.StatusBadRequest,
+			Code:    "AF20051",
+			Want:    []error{ErrContentExpired, ErrBadRequest},
+			WantNot: []error{ErrSubscriptionNotEnabled},
+		},
+		{
+			Status:  http.StatusForbidden,
+			Code:    "AF20011",
+			Want:    []error{ErrTenantNotProvisioned},
+			WantNot: []error{ErrBadRequest},
+		},
+		{
+			Status: http.StatusBadRequest,
+			Code:   "AF20020",
+			Want:   []error{ErrInvalidContentType},
+		},
+		{
+			Status: http.StatusBadRequest,
+			Code:   "AF20021",
+			Want:   []error{ErrWebhookValidationFailed},
+		},
+		{
+			Status: http.StatusTooManyRequests,
+			Code:   "AF429",
+			Want:   []error{ErrThrottled},
+		},
+		{
+			Status:  http.StatusNotFound,
+			Code:    "",
+			Want:    []error{ErrNotFound},
+			WantNot: []error{ErrContentNotFound},
+		},
+	}
+
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			status = c.Status
+			body = ""
+			if c.Code != "" {
+				body = fmt.Sprintf(`{"error": {"code": "%s", "message": "test"}}`, c.Code)
+			}
+			ct := schema.AuditGeneral
+			_, _, err := client.Content.List(context.Background(), &ct, time.Time{}, time.Time{})
+
+			var errResp *ErrorResponse
+			if !errors.As(err, &errResp) {
+				t.Fatalf("got error %v but want *ErrorResponse", err)
+			}
+			if errResp.Code() != c.Code {
+				t.Errorf("got code %q but want %q", errResp.Code(), c.Code)
+			}
+			for _, want := range c.Want {
+				if !errors.Is(err, want) {
+					t.Errorf("error %v does not match %v", err, want)
+				}
+			}
+			for _, wantNot := range c.WantNot {
+				if errors.Is(err, wantNot) {
+					t.Errorf("error %v must not match %v", err, wantNot)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	// error codes as documented by the Management Activity API reference
+	cases := []struct {
+		Code string
+		Want error
+	}{
+		{"AF10001", ErrPermissionDenied},
+		{"AF20010", ErrTenantMismatch},
+		{"AF20011", ErrTenantNotProvisioned},
+		{"AF20012", ErrTenantNotProvisioned},
+		{"AF20013", ErrInvalidTenant},
+		{"AF20020", ErrInvalidContentType},
+		{"AF20053", ErrInvalidContentType},
+		{"AF20021", ErrWebhookValidationFailed},
+		{"AF20022", ErrSubscriptionNotEnabled},
+		{"AF20023", ErrSubscriptionNotEnabled},
+		{"AF20030", ErrInvalidTimeWindow},
+		{"AF20054", ErrInvalidTimeWindow},
+		{"AF20055", ErrInvalidTimeWindow},
+		{"AF20056", ErrInvalidTimeWindow},
+		{"AF20031", ErrInvalidNextPage},
+		{"AF20050", ErrContentNotFound},
+		{"AF20052", ErrContentNotFound},
+		{"AF20051", ErrContentExpired},
+		{"AF429", ErrThrottled},
+		{"AF50000", ErrInternal},
+	}
+	if len(cases) != len(apiErrorCodes) {
+		t.Errorf("got %d error codes but %d are tested", len(apiErrorCodes), len(cases))
+	}
+
+	for _, c := range cases {
+		t.Run(c.Code, func(t *testing.T) {
+			resp := &ErrorResponse{Err: &Error{}}
+			resp.Err.Error.Code = c.Code
+			if !errors.Is(resp, c.Want) {
+				t.Errorf("error code %s does not match %v", c.Code, c.Want)
+			}
+			for _, other := range apiErrorCodes {
+				if other != c.Want && errors.Is(resp, other) {
+					t.Errorf("error code %s must not match %v", c.Code, other)
+				}
+			}
+		})
+	}
+}
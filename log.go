@@ -0,0 +1,14 @@
+package office365
+
+import "log/slog"
+
+// loggerOrDefault returns the provided logger,
+// or the default slog logger if nil.
+// Other logging libraries can be plugged in through a slog.Handler,
+// see the logrushandler module for logrus.
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,10 @@
+module github.com/orlangure/go-office365/logrushandler
+
+go 1.21
+
+require github.com/sirupsen/logrus v1.5.0
+
+require (
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+)
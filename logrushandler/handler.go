@@ -0,0 +1,106 @@
+// Package logrushandler provides a slog.Handler writing to a logrus.Logger,
+// for using logrus with the watchers and handlers of the office365 package.
+// It is a module of its own, so that office365 does not depend on logrus.
+package logrushandler
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is a slog.Handler writing records to a logrus.Logger.
+// Attributes are turned into logrus fields, attributes of groups
+// being prefixed with the group names.
+type Handler struct {
+	logger *logrus.Logger
+	fields logrus.Fields
+	prefix string
+}
+
+// New returns a Handler writing to the provided logger.
+func New(l *logrus.Logger) *Handler {
+	return &Handler{logger: l, fields: logrus.Fields{}}
+}
+
+// NewLogger returns a slog.Logger writing to the provided logger.
+func NewLogger(l *logrus.Logger) *slog.Logger {
+	return slog.New(New(l))
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.IsLevelEnabled(logrusLevel(level))
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(logrus.Fields, len(h.fields)+r.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addField(fields, h.prefix, a)
+		return true
+	})
+	entry := h.logger.WithFields(fields)
+	if !r.Time.IsZero() {
+		entry = entry.WithTime(r.Time)
+	}
+	entry.Log(logrusLevel(r.Level), r.Message)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(logrus.Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, a := range attrs {
+		addField(fields, h.prefix, a)
+	}
+	return &Handler{logger: h.logger, fields: fields, prefix: h.prefix}
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &Handler{logger: h.logger, fields: h.fields, prefix: h.prefix + name + "."}
+}
+
+// addField adds the attribute to fields, flattening groups.
+func addField(fields logrus.Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if a.Key != "" {
+			groupPrefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addField(fields, groupPrefix, ga)
+		}
+		return
+	}
+	fields[prefix+a.Key] = a.Value.Any()
+}
+
+// logrusLevel maps a slog.Level to the closest logrus.Level.
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return logrus.DebugLevel
+	case level < slog.LevelWarn:
+		return logrus.InfoLevel
+	case level < slog.LevelError:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
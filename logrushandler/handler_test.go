@@ -0,0 +1,39 @@
+package logrushandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.SetLevel(logrus.InfoLevel)
+
+	logger := NewLogger(l).With("content-type", "Audit.General")
+	logger.Debug("skipped")
+	logger.WithGroup("window").Warn("fetching", "start", 1, slog.Group("range", "end", 2))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single json entry, got %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"content-type":     "Audit.General",
+		"window.start":     float64(1),
+		"window.range.end": float64(2),
+		"level":            "warning",
+		"msg":              "fetching",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("got %s=%v but want %v", k, entry[k], v)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package office365
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// Operation names the logical API operation a request is sent for.
+type Operation string
+
+// Operations of the Management Activity API.
+const (
+	OperationSubscriptionList  Operation = "subscriptions/list"
+	OperationSubscriptionStart Operation = "subscriptions/start"
+	OperationSubscriptionStop  Operation = "subscriptions/stop"
+	OperationContentList       Operation = "content/list"
+	OperationAuditFetch        Operation = "audit/fetch"
+)
+
+// RequestInfo describes the logical operation a request is sent for.
+type RequestInfo struct {
+	Operation Operation
+	// ContentType is nil when the operation is not bound to a content type,
+	// or when it is unknown, e.g. when fetching audits without ContextWithContentType.
+	ContentType *schema.ContentType
+}
+
+// Handler sends a request and returns its response.
+type Handler func(*http.Request) (*http.Response, error)
+
+// Interceptor is called for every attempt of every request sent by a Client.
+// It may modify the request, call next for sending it, and inspect or replace
+// the response before returning it.
+type Interceptor func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error)
+
+// WithInterceptors appends the provided interceptors to the client chain.
+// Interceptors are called in order, the first one being the outermost.
+func WithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(c *Client) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+type requestInfoKey struct{}
+
+// ContextWithContentType returns a copy of ctx holding the content type
+// reported to interceptors for operations not receiving it as an argument,
+// such as fetching audits.
+func ContextWithContentType(ctx context.Context, ct *schema.ContentType) context.Context {
+	info := requestInfoFromContext(ctx)
+	info.ContentType = ct
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// withOperation returns a copy of ctx holding the operation reported to interceptors.
+// The content type already held by ctx is kept if ct is nil.
+func withOperation(ctx context.Context, op Operation, ct *schema.ContentType) context.Context {
+	info := requestInfoFromContext(ctx)
+	info.Operation = op
+	if ct != nil {
+		info.ContentType = ct
+	}
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfoFromContext returns the RequestInfo held by ctx, if any.
+func requestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// send sends the request through the client interceptors.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	hc := c.httpClient(callSettingsFromContext(req.Context()))
+	if len(c.interceptors) == 0 {
+		return hc.Do(req)
+	}
+	info := requestInfoFromContext(req.Context())
+	h := Handler(hc.Do)
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], h
+		h = func(r *http.Request) (*http.Response, error) {
+			return interceptor(info, r, next)
+		}
+	}
+	return h(req)
+}
+
+// RequestIDInterceptor sets a random client-request-id header on requests
+// not holding one yet, and asks the API to return it in the response.
+// The identifier is kept when a request is retried.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#activity-api-operations
+func RequestIDInterceptor() Interceptor {
+	return func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+		if req.Header.Get("client-request-id") == "" {
+			id, err := newRequestID()
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("client-request-id", id)
+			req.Header.Set("return-client-request-id", "true")
+		}
+		return next(req)
+	}
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// DumpInterceptor writes every request and response to w, bodies included,
+// preceded by the operation they are sent for.
+// Dumping a response reads its whole body in memory, it is meant for debugging only.
+// The Authorization header is not part of the dump, as it is set by the
+// underlying http.Client transport.
+func DumpInterceptor(w io.Writer) Interceptor {
+	var mu sync.Mutex
+	dump := func(info RequestInfo, kind string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "--- %s %s", kind, info.Operation)
+		if info.ContentType != nil {
+			fmt.Fprintf(w, " %s", info.ContentType.String())
+		}
+		fmt.Fprintf(w, "\n%s\n", data)
+	}
+
+	return func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+		if data, err := httputil.DumpRequestOut(req, true); err == nil {
+			dump(info, "request", data)
+		}
+		resp, err := next(req)
+		if err != nil {
+			return resp, err
+		}
+		if data, err := httputil.DumpResponse(resp, true); err == nil {
+			dump(info, "response", data)
+		}
+		return resp, nil
+	}
+}
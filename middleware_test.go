@@ -0,0 +1,112 @@
+package office365
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestInterceptorsOrder(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+			ct := ""
+			if info.ContentType != nil {
+				ct = info.ContentType.String()
+			}
+			calls = append(calls, fmt.Sprintf("%s>%s:%s", name, info.Operation, ct))
+			resp, err := next(req)
+			calls = append(calls, "<"+name)
+			return resp, err
+		}
+	}
+	WithInterceptors(record("a"), record("b"))(client)
+
+	mux.HandleFunc(client.getURL("subscriptions/stop", nil).Path, func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc(client.getURL("audit/abc", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	ct := schema.AuditGeneral
+	if _, err := client.Subscription.Stop(context.Background(), &ct); err != nil {
+		t.Fatalf("error occurred running Subscriptions.Stop: %v", err)
+	}
+	ctx := ContextWithContentType(context.Background(), &ct)
+	if _, _, err := client.Audit.List(ctx, "abc", false); err != nil {
+		t.Fatalf("error occurred running Audit.List: %v", err)
+	}
+
+	want := []string{
+		"a>subscriptions/stop:Audit.General", "b>subscriptions/stop:Audit.General", "<b", "<a",
+		"a>audit/fetch:Audit.General", "b>audit/fetch:Audit.General", "<b", "<a",
+	}
+	testDeep(t, calls, want)
+}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+	WithInterceptors(RequestIDInterceptor())(client)
+
+	var ids []string
+	mux.HandleFunc(client.getURL("subscriptions/list", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("client-request-id"))
+		if r.Header.Get("return-client-request-id") != "true" {
+			t.Errorf("return-client-request-id header is not set")
+		}
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	if _, _, err := client.Subscription.List(context.Background()); err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if len(ids) != 2 || !uuid.MatchString(ids[0]) || ids[0] != ids[1] {
+		t.Errorf("got request ids %v but want the same uuid on both attempts", ids)
+	}
+}
+
+func TestDumpInterceptor(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	var buf bytes.Buffer
+	WithInterceptors(DumpInterceptor(&buf))(client)
+
+	mux.HandleFunc(client.getURL("subscriptions/start", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"contentType": "Audit.General", "status": "enabled"}`)
+	})
+
+	ct := schema.AuditGeneral
+	_, sub, err := client.Subscription.Start(context.Background(), &ct, &Webhook{Address: String("test-address")})
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+	testDeep(t, sub, &Subscription{ContentType: String("Audit.General"), Status: String("enabled")})
+
+	dump := buf.String()
+	for _, want := range []string{
+		"--- request subscriptions/start Audit.General",
+		`"address":"test-address"`,
+		"--- response subscriptions/start Audit.General",
+		`"status": "enabled"`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump does not contain %q:\n%s", want, dump)
+		}
+	}
+}
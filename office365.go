@@ -10,6 +10,9 @@ import (
 	"net/url"
 	"time"
 
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
@@ -24,8 +27,6 @@ var (
 	defaultVersion   = "v1.0"
 	defaultUserAgent = "go-office365"
 	defaultTimeout   = 5 * time.Second
-
-	microsoftTokenURL = "https://login.windows.net/%s/oauth2/token?api-version=1.0"
 )
 
 var (
@@ -41,16 +42,50 @@ type Credentials struct {
 	ClientSecret string
 	TenantDomain string
 	TenantID     string
+
+	// Certificate authenticates the application with a signed client assertion.
+	// It takes precedence over ClientSecret when set.
+	Certificate *ClientCertificate
+
+	// TokenSource provides the access tokens, bypassing every other credential.
+	// See NewV2TokenSource, NewWorkloadIdentityTokenSource and NewManagedIdentityTokenSource,
+	// whose tokens are renewed before they expire.
+	// Other sources are wrapped in an oauth2.ReuseTokenSource.
+	TokenSource oauth2.TokenSource
+
+	// Environment is the Microsoft cloud the tenant lives in.
+	// EnvironmentCommercial is used if nil.
+	Environment *Environment
 }
 
 // OAuthClient returns an authenticated httpClient using the provided credentials.
+// Tokens obtained with a Certificate are cached and renewed before they expire.
 func OAuthClient(ctx context.Context, c *Credentials) *http.Client {
+	env := environmentOrDefault(c.Environment)
+	if c.TokenSource != nil {
+		src := c.TokenSource
+		if _, ok := src.(*cachingTokenSource); !ok {
+			src = oauth2.ReuseTokenSource(nil, src)
+		}
+		return tokenClient(ctx, src)
+	}
+	if c.Certificate != nil {
+		tokenURL := env.TokenURL(c.TenantDomain)
+		src := &assertionTokenSource{
+			ctx:       ctx,
+			clientID:  c.ClientID,
+			tokenURL:  tokenURL,
+			params:    url.Values{"resource": []string{env.Resource}},
+			assertion: certificateAssertion(c.Certificate, c.ClientID, tokenURL),
+		}
+		return tokenClient(ctx, newCachingTokenSource(src, defaultRefreshWindow))
+	}
 	conf := &clientcredentials.Config{
 		ClientID:     c.ClientID,
 		ClientSecret: c.ClientSecret,
-		TokenURL:     fmt.Sprintf(microsoftTokenURL, c.TenantDomain),
+		TokenURL:     env.TokenURL(c.TenantDomain),
 		EndpointParams: url.Values{
-			"resource": []string{defaultBaseURL},
+			"resource": []string{env.Resource},
 		},
 	}
 	return conf.Client(ctx)
@@ -63,9 +98,18 @@ type Client struct {
 	UserAgent string
 	version   string
 
+	// RetryPolicy is applied to every request sent by the client.
+	RetryPolicy RetryPolicy
+
 	client        *http.Client
 	tenantID      string
 	pubIdentifier string
+	limiters      *RateLimiters
+	interceptors  []Interceptor
+
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	telemetry      *telemetry
 
 	// inspired by go-github:
 	// https://github.com/google/go-github/blob/d913de9ce1e8ed5550283b448b37b721b61cc3b3/github/github.go#L159
@@ -82,7 +126,8 @@ type Client struct {
 // Note that the default client has no way of authenticating itself against
 // the Microsoft Office365 Management Activity  API.
 // A convenience function is provided just for that: NewClientAuthenticated.
-func NewClient(httpClient *http.Client, tenantID string, pubIdentifier string) *Client {
+// Options are applied in order once the default values are set.
+func NewClient(httpClient *http.Client, tenantID string, pubIdentifier string, opts ...ClientOption) *Client {
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: defaultTimeout}
 	}
@@ -95,6 +140,7 @@ func NewClient(httpClient *http.Client, tenantID string, pubIdentifier string) *
 		BaseURL:       baseURL,
 		UserAgent:     defaultUserAgent,
 		version:       defaultVersion,
+		RetryPolicy:   DefaultRetryPolicy,
 		client:        httpClient,
 		tenantID:      tenantID,
 		pubIdentifier: pubIdentifier,
@@ -104,6 +150,11 @@ func NewClient(httpClient *http.Client, tenantID string, pubIdentifier string) *
 	c.Subscription = (*SubscriptionService)(&c.common)
 	c.Content = (*ContentService)(&c.common)
 	c.Audit = (*AuditService)(&c.common)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.telemetry = newTelemetry(c.tracerProvider, c.meterProvider)
 	return c
 }
 
@@ -115,16 +166,23 @@ func (c *Client) Version() string {
 // NewClientAuthenticated returns an authenticated Client.
 // pubIdentifier is used on Microsoft side to group queries
 // together in terms of quotas and limitations.
-func NewClientAuthenticated(c *Credentials, pubIdentifier string) *Client {
+// The client queries the management host of the credentials Environment.
+func NewClientAuthenticated(c *Credentials, pubIdentifier string, opts ...ClientOption) *Client {
 	oauthClient := OAuthClient(context.Background(), c)
-	return NewClient(oauthClient, c.TenantID, pubIdentifier)
+	opts = append([]ClientOption{WithEnvironment(environmentOrDefault(c.Environment))}, opts...)
+	return NewClient(oauthClient, c.TenantID, pubIdentifier, opts...)
 }
 
 // newRequest generates a http.Request based on the method
 // and endpoint provided. Default headers are also set here.
 func (c *Client) newRequest(method, path string, params url.Values, payload io.Reader) (*http.Request, error) {
-	url := c.getURL(path, params)
-	req, err := http.NewRequest(method, url.String(), payload)
+	return c.newRequestURL(method, c.getURL(path, params), payload)
+}
+
+// newRequestURL generates a http.Request for the method and the absolute URL provided.
+// Default headers are also set here.
+func (c *Client) newRequestURL(method string, u *url.URL, payload io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), payload)
 	if err != nil {
 		return nil, err
 	}
@@ -146,38 +204,58 @@ func (c *Client) getURL(path string, params url.Values) *url.URL {
 
 // do performs a roundtrip using the underlying client
 // and returns an error, if any.
+// Throttled and transient failures are retried according to the client RetryPolicy.
 // It will also try to decode the body into the provided out interface.
 // It returns the response and any error from decoding.
 func (c *Client) do(ctx context.Context, req *http.Request, out interface{}) (*Response, error) {
+	var decode func(io.Reader) error
+	if out != nil {
+		decode = func(body io.Reader) error {
+			err := json.NewDecoder(body).Decode(&out)
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+	}
+	return c.doStream(ctx, req, decode)
+}
+
+// doStream performs a roundtrip like do, handing the body of successful
+// responses to the provided decode function instead.
+func (c *Client) doStream(ctx context.Context, req *http.Request, decode func(io.Reader) error) (*Response, error) {
 	if ctx == nil {
 		return nil, errors.New("context must be non-nil")
 	}
+	settings := callSettingsFromContext(ctx)
 	req = req.WithContext(ctx)
-	resp, err := c.client.Do(req)
+	start := time.Now()
+	resp, attempts, err := c.roundTrip(ctx, req)
 	if err != nil {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-		}
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	response := &Response{resp}
-
-	err = CheckResponse(resp)
+	response := &Response{Response: resp, ResponseMeta: newResponseMeta(resp)}
+	response.Attempts = attempts
+	response.Duration = time.Since(start)
 
-	if out != nil {
-		decErr := json.NewDecoder(resp.Body).Decode(&out)
-		if decErr == io.EOF {
-			decErr = nil
+	if err := CheckResponse(resp); err != nil {
+		if errorResponse, ok := err.(*ErrorResponse); ok {
+			errorResponse.ResponseMeta = response.ResponseMeta
+		}
+		return response, err
+	}
+	if decode != nil {
+		body, err := settings.limitBody(resp)
+		if err != nil {
+			return response, err
 		}
-		if err == nil {
-			err = decErr
+		if err := decode(body); err != nil {
+			return response, err
 		}
 	}
-	return response, err
+	return response, nil
 }
 
 // CheckResponse validates the response returned from
@@ -186,7 +264,7 @@ func CheckResponse(r *http.Response) error {
 	if c := r.StatusCode; 200 <= c && c <= 299 {
 		return nil
 	}
-	errorResponse := &ErrorResponse{Response: r}
+	errorResponse := &ErrorResponse{Response: r, ResponseMeta: newResponseMeta(r)}
 	data, err := io.ReadAll(r.Body)
 	if err == nil && data != nil {
 		_ = json.Unmarshal(data, &errorResponse.Err)
@@ -198,6 +276,7 @@ func CheckResponse(r *http.Response) error {
 // a successful API call.
 type Response struct {
 	Response *http.Response
+	ResponseMeta
 }
 
 // ErrorResponse encapsulates the http response as well as the
@@ -205,12 +284,13 @@ type Response struct {
 type ErrorResponse struct {
 	Response *http.Response
 	Err      *Error
+	ResponseMeta
 }
 
 func (r *ErrorResponse) Error() string {
-	return fmt.Sprintf("%v %v: %d %v. API Error: %+v",
+	return fmt.Sprintf("%v %v: %d %v. API Error: %+v%s",
 		r.Response.Request.Method, r.Response.Request.URL,
-		r.Response.StatusCode, r.Response.Status, r.Err)
+		r.Response.StatusCode, r.Response.Status, r.Err, r.ResponseMeta.errorDetails())
 }
 
 // Error represents the json object returned in the body
@@ -233,3 +313,11 @@ func Int(v int) *int { return &v }
 // String is a helper routine that allocates a new string value
 // to store v and returns a pointer to it.
 func String(v string) *string { return &v }
+
+// stringValue returns the value s points to, or an empty string if nil.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -29,6 +29,9 @@ func TestClientDefaults(t *testing.T) {
 	if version != defaultVersion {
 		t.Errorf("Version is not default value. got: %v want: %v", version, defaultVersion)
 	}
+	if client.RetryPolicy != DefaultRetryPolicy {
+		t.Errorf("RetryPolicy is not default value. got: %+v want: %+v", client.RetryPolicy, DefaultRetryPolicy)
+	}
 	if pubIdentifier == "" && client.pubIdentifier != client.tenantID {
 		t.Errorf("pubIdentifier is not default value(tenantID). got: %v want: %v", client.pubIdentifier, client.tenantID)
 	}
@@ -0,0 +1,669 @@
+// Package office365test provides an in-process fake of the Office 365
+// Management Activity API, for testing code built on the office365 package
+// without a tenant.
+//
+// The Server keeps subscriptions per tenant and content type, serves the
+// content blobs added to it with NextPageUri paging, enforces the time window
+// rules of the API and expires content. Faults can be injected for exercising
+// retries and error handling, and webhooks receive notifications as content
+// is added.
+package office365test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// Operation names an operation of the API.
+// The values match the ones reported to office365 interceptors.
+type Operation string
+
+// Operations of the Management Activity API.
+const (
+	OperationSubscriptionList  Operation = "subscriptions/list"
+	OperationSubscriptionStart Operation = "subscriptions/start"
+	OperationSubscriptionStop  Operation = "subscriptions/stop"
+	OperationContentList       Operation = "content/list"
+	OperationAuditFetch        Operation = "audit/fetch"
+)
+
+// Request formats accepted for the startTime and endTime query parameters.
+var requestDatetimeFormats = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// createdDatetimeFormat is the format of contentCreated and contentExpiration.
+const createdDatetimeFormat = "2006-01-02T15:04:05.999Z"
+
+var (
+	defaultPageSize   = 100
+	defaultContentTTL = 7 * 24 * time.Hour
+	intervalOneDay    = 24 * time.Hour
+	intervalOneWeek   = 7 * intervalOneDay
+)
+
+// Option configures a Server created with NewServer.
+type Option func(*Server)
+
+// WithClock makes the server use the provided clock for windows and expiration.
+// time.Now is used by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithPageSize sets the maximum number of blobs listed per page. It defaults to 100.
+func WithPageSize(n int) Option {
+	return func(s *Server) {
+		s.pageSize = n
+	}
+}
+
+// WithContentTTL sets the time after which content expires. It defaults to 7 days.
+func WithContentTTL(d time.Duration) Option {
+	return func(s *Server) {
+		s.contentTTL = d
+	}
+}
+
+// Fault makes the server fail requests instead of serving them.
+type Fault struct {
+	// Operation is the operation failing, every operation if empty.
+	Operation Operation
+	// TenantID is the tenant failing, every tenant if empty.
+	TenantID string
+	// StatusCode is the http status code returned.
+	StatusCode int
+	// Code is the API error code returned in the body, e.g. AF429.
+	Code string
+	// RetryAfter is returned in the Retry-After header of 429 and 503 responses.
+	RetryAfter time.Duration
+	// Times is the number of requests failing before the fault is removed.
+	// Zero means every request fails until the server is closed.
+	Times int
+}
+
+// Server is a fake Management Activity API.
+// It serves any tenant, tenants being created on first use.
+type Server struct {
+	*httptest.Server
+
+	now        func() time.Time
+	pageSize   int
+	contentTTL time.Duration
+	webhooks   *http.Client
+
+	mu       sync.Mutex
+	tenants  map[string]*tenant
+	faults   []*Fault
+	requests map[Operation]int
+	seq      int
+}
+
+// tenant holds the state of a single tenant.
+type tenant struct {
+	subscriptions map[schema.ContentType]*subscription
+	blobs         map[string]*blob
+}
+
+// subscription is the subscription of a tenant to a content type.
+type subscription struct {
+	enabled bool
+	webhook *webhook
+}
+
+// webhook is the webhook of a subscription, as sent by clients.
+type webhook struct {
+	Address    string `json:"address"`
+	AuthID     string `json:"authId,omitempty"`
+	Expiration string `json:"expiration,omitempty"`
+
+	// disabled is set by DisableWebhook, until the subscription is started again.
+	disabled bool
+}
+
+// status returns the status of the webhook as returned by the API.
+func (wh *webhook) status(now time.Time) string {
+	if wh.disabled {
+		return "disabled"
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05"} {
+		if expiration, err := time.Parse(layout, wh.Expiration); err == nil {
+			if !expiration.After(now) {
+				return "expired"
+			}
+			break
+		}
+	}
+	return "enabled"
+}
+
+// blob is a content blob and its records.
+type blob struct {
+	contentType schema.ContentType
+	id          string
+	created     time.Time
+	expiration  time.Time
+	records     []json.RawMessage
+}
+
+// NewServer starts and returns a new Server. Close must be called once done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		now:        time.Now,
+		pageSize:   defaultPageSize,
+		contentTTL: defaultContentTTL,
+		webhooks:   &http.Client{Timeout: 5 * time.Second},
+		tenants:    make(map[string]*tenant),
+		requests:   make(map[Operation]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL returns the URL to set as office365.Client BaseURL.
+func (s *Server) BaseURL() *url.URL {
+	u, _ := url.Parse(s.URL + "/")
+	return u
+}
+
+// ContentURI returns the content URI of a blob, as listed by the server.
+func (s *Server) ContentURI(tenantID, contentID string) string {
+	return fmt.Sprintf("%s/api/v1.0/%s/activity/feed/audit/%s", s.URL, tenantID, contentID)
+}
+
+// StartSubscription enables the subscription of the tenant to the content type.
+func (s *Server) StartSubscription(tenantID string, ct schema.ContentType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tenant(tenantID).subscription(ct).enabled = true
+}
+
+// StopSubscription disables the subscription of the tenant to the content type.
+func (s *Server) StopSubscription(tenantID string, ct schema.ContentType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tenant(tenantID).subscription(ct).enabled = false
+}
+
+// DisableWebhook disables the webhook of the subscription of the tenant to the content type,
+// as Microsoft does after excessive failed notifications.
+// The webhook is enabled again when the subscription is started.
+func (s *Server) DisableWebhook(tenantID string, ct schema.ContentType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if wh := s.tenant(tenantID).subscription(ct).webhook; wh != nil {
+		wh.disabled = true
+	}
+}
+
+// AddContent adds a content blob holding the provided records, created at the provided time,
+// and returns its contentId.
+// The webhook of the subscription, if any, is notified before AddContent returns.
+// Delivery failures are ignored, as the content can still be listed.
+func (s *Server) AddContent(tenantID string, ct schema.ContentType, created time.Time, records ...interface{}) (string, error) {
+	raw := make([]json.RawMessage, 0, len(records))
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		raw = append(raw, data)
+	}
+
+	s.mu.Lock()
+	t := s.tenant(tenantID)
+	s.seq++
+	b := &blob{
+		contentType: ct,
+		id:          fmt.Sprintf("%s%08d", created.UTC().Format("20060102150405"), s.seq),
+		created:     created,
+		expiration:  created.Add(s.contentTTL),
+		records:     raw,
+	}
+	t.blobs[b.id] = b
+	var wh *webhook
+	if sub := t.subscriptions[ct]; sub != nil && sub.enabled && sub.webhook != nil && sub.webhook.status(s.now()) == "enabled" {
+		wh = sub.webhook
+	}
+	notification := s.content(tenantID, b)
+	s.mu.Unlock()
+
+	if wh != nil {
+		s.notify(wh, []contentJSON{notification})
+	}
+	return b.id, nil
+}
+
+// GenerateContent adds a blob every interval, from start included to end excluded,
+// each blob holding the provided number of generated records.
+// It returns the contentId of the blobs added.
+func (s *Server) GenerateContent(tenantID string, ct schema.ContentType, start, end time.Time, every time.Duration, recordsPerBlob int) []string {
+	var ids []string
+	for created := start; created.Before(end); created = created.Add(every) {
+		records := make([]interface{}, recordsPerBlob)
+		for i := range records {
+			records[i] = map[string]interface{}{
+				"Id":             fmt.Sprintf("%s-%s-%d-%d", tenantID, ct, created.UnixNano(), i),
+				"CreationTime":   created.UTC().Format("2006-01-02T15:04:05"),
+				"Operation":      "FakeOperation",
+				"OrganizationId": tenantID,
+				"Workload":       strings.TrimPrefix(ct.String(), "Audit."),
+				"UserId":         "user@example.com",
+			}
+		}
+		id, _ := s.AddContent(tenantID, ct, created, records...)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Inject adds a fault. Faults are checked in the order they were injected.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &f)
+}
+
+// Throttle makes the next times requests of the operation fail with a 429 status.
+func (s *Server) Throttle(op Operation, times int) {
+	s.Inject(Fault{Operation: op, StatusCode: http.StatusTooManyRequests, Code: "AF429", Times: times})
+}
+
+// Requests returns the number of requests received for the operation, failed ones included.
+func (s *Server) Requests(op Operation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[op]
+}
+
+// tenant returns the tenant, creating it if needed. It must be called with s.mu held.
+func (s *Server) tenant(id string) *tenant {
+	t, ok := s.tenants[id]
+	if !ok {
+		t = &tenant{
+			subscriptions: make(map[schema.ContentType]*subscription),
+			blobs:         make(map[string]*blob),
+		}
+		s.tenants[id] = t
+	}
+	return t
+}
+
+// subscription returns the subscription to the content type, creating a disabled one if needed.
+func (t *tenant) subscription(ct schema.ContentType) *subscription {
+	sub, ok := t.subscriptions[ct]
+	if !ok {
+		sub = &subscription{}
+		t.subscriptions[ct] = sub
+	}
+	return sub
+}
+
+// fault returns the first fault matching the request, if any. It must be called with s.mu held.
+func (s *Server) fault(tenantID string, op Operation) *Fault {
+	for i, f := range s.faults {
+		if (f.Operation != "" && f.Operation != op) || (f.TenantID != "" && f.TenantID != tenantID) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// apiError is the body of failed responses.
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError writes a failed response.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var e apiError
+	e.Error.Code = code
+	e.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// writeJSON writes a successful response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// serveHTTP routes /api/{version}/{tenantID}/activity/feed/{operation} requests.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	tokens := strings.SplitN(r.URL.Path, "/", 7)
+	if len(tokens) != 7 || tokens[1] != "api" || tokens[4] != "activity" || tokens[5] != "feed" {
+		http.NotFound(w, r)
+		return
+	}
+	tenantID, path := tokens[3], tokens[6]
+
+	var op Operation
+	switch {
+	case path == "subscriptions/list" && r.Method == http.MethodGet:
+		op = OperationSubscriptionList
+	case path == "subscriptions/start" && r.Method == http.MethodPost:
+		op = OperationSubscriptionStart
+	case path == "subscriptions/stop" && r.Method == http.MethodPost:
+		op = OperationSubscriptionStop
+	case path == "subscriptions/content" && r.Method == http.MethodGet:
+		op = OperationContentList
+	case strings.HasPrefix(path, "audit/") && r.Method == http.MethodGet:
+		op = OperationAuditFetch
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[op]++
+	f := s.fault(tenantID, op)
+	s.mu.Unlock()
+
+	if f != nil {
+		if f.StatusCode == http.StatusTooManyRequests || f.StatusCode == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", strconv.Itoa(int(f.RetryAfter/time.Second)))
+		}
+		writeError(w, f.StatusCode, f.Code, "injected fault")
+		return
+	}
+
+	switch op {
+	case OperationSubscriptionList:
+		s.listSubscriptions(w, tenantID)
+	case OperationSubscriptionStart:
+		s.startSubscription(w, r, tenantID)
+	case OperationSubscriptionStop:
+		s.stopSubscription(w, r, tenantID)
+	case OperationContentList:
+		s.listContent(w, r, tenantID)
+	case OperationAuditFetch:
+		s.fetchAudit(w, tenantID, strings.TrimPrefix(path, "audit/"))
+	}
+}
+
+// subscriptionJSON is a subscription as returned by the API.
+type subscriptionJSON struct {
+	ContentType string       `json:"contentType"`
+	Status      string       `json:"status"`
+	Webhook     *webhookJSON `json:"webhook"`
+}
+
+// webhookJSON is a webhook as returned by the API.
+type webhookJSON struct {
+	Status string `json:"status"`
+	webhook
+}
+
+// json returns the subscription as returned by the API at the provided time.
+func (sub *subscription) json(ct schema.ContentType, now time.Time) subscriptionJSON {
+	out := subscriptionJSON{ContentType: ct.String(), Status: "disabled"}
+	if sub.enabled {
+		out.Status = "enabled"
+	}
+	if sub.webhook != nil {
+		out.Webhook = &webhookJSON{Status: sub.webhook.status(now), webhook: *sub.webhook}
+	}
+	return out
+}
+
+func (s *Server) listSubscriptions(w http.ResponseWriter, tenantID string) {
+	s.mu.Lock()
+	t := s.tenant(tenantID)
+	out := []subscriptionJSON{}
+	for ct, sub := range t.subscriptions {
+		out = append(out, sub.json(ct, s.now()))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ContentType < out[j].ContentType })
+	writeJSON(w, out)
+}
+
+// contentType parses the contentType query parameter, writing an error if invalid.
+func contentType(w http.ResponseWriter, r *http.Request) (schema.ContentType, bool) {
+	ct, err := schema.GetContentType(r.URL.Query().Get("contentType"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "AF20020", "The specified content type is not valid.")
+		return 0, false
+	}
+	return *ct, true
+}
+
+func (s *Server) startSubscription(w http.ResponseWriter, r *http.Request, tenantID string) {
+	ct, ok := contentType(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Webhook *webhook `json:"webhook"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "AF20000", fmt.Sprintf("Invalid payload: %s", err))
+			return
+		}
+	}
+	if payload.Webhook != nil && !s.validateWebhook(payload.Webhook) {
+		writeError(w, http.StatusBadRequest, "AF20021", "The webhook endpoint could not be validated.")
+		return
+	}
+
+	s.mu.Lock()
+	sub := s.tenant(tenantID).subscription(ct)
+	sub.enabled = true
+	sub.webhook = payload.Webhook
+	out := sub.json(ct, s.now())
+	s.mu.Unlock()
+
+	writeJSON(w, out)
+}
+
+func (s *Server) stopSubscription(w http.ResponseWriter, r *http.Request, tenantID string) {
+	ct, ok := contentType(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.tenant(tenantID).subscriptions[ct]
+	if !ok || !sub.enabled {
+		writeError(w, http.StatusBadRequest, "AF20022", "No subscription found for the specified content type.")
+		return
+	}
+	sub.enabled = false
+}
+
+// contentJSON is a content blob as returned by the API and sent to webhooks.
+type contentJSON struct {
+	TenantID          string `json:"tenantId"`
+	ContentType       string `json:"contentType"`
+	ContentID         string `json:"contentId"`
+	ContentURI        string `json:"contentUri"`
+	ContentCreated    string `json:"contentCreated"`
+	ContentExpiration string `json:"contentExpiration"`
+}
+
+// content returns the blob as returned by the API.
+func (s *Server) content(tenantID string, b *blob) contentJSON {
+	return contentJSON{
+		TenantID:          tenantID,
+		ContentType:       b.contentType.String(),
+		ContentID:         b.id,
+		ContentURI:        s.ContentURI(tenantID, b.id),
+		ContentCreated:    b.created.UTC().Format(createdDatetimeFormat),
+		ContentExpiration: b.expiration.UTC().Format(createdDatetimeFormat),
+	}
+}
+
+// parseRequestTime parses a startTime or endTime query parameter, as UTC.
+func parseRequestTime(v string) (time.Time, bool) {
+	for _, layout := range requestDatetimeFormats {
+		if t, err := time.ParseInLocation(layout, v, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (s *Server) listContent(w http.ResponseWriter, r *http.Request, tenantID string) {
+	ct, ok := contentType(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	now := s.now()
+
+	start, end := now.Add(-intervalOneDay), now
+	startStr, endStr := params.Get("startTime"), params.Get("endTime")
+	if startStr != "" || endStr != "" {
+		var startOK, endOK bool
+		start, startOK = parseRequestTime(startStr)
+		end, endOK = parseRequestTime(endStr)
+		switch {
+		case !startOK || !endOK:
+			writeError(w, http.StatusBadRequest, "AF20055", "Start time and end time must both be specified (or both omitted) and must be valid dates.")
+			return
+		case !end.After(start), end.Sub(start) > intervalOneDay:
+			writeError(w, http.StatusBadRequest, "AF20055", "Start time and end time must be less than or equal to 24 hours apart.")
+			return
+		case start.Before(now.Add(-intervalOneWeek)):
+			writeError(w, http.StatusBadRequest, "AF20055", "Start time must be no more than 7 days in the past.")
+			return
+		}
+	}
+
+	offset := 0
+	if token := params.Get("nextpage"); token != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(token, "page"))
+		if err != nil || !strings.HasPrefix(token, "page") || n <= 0 {
+			writeError(w, http.StatusBadRequest, "AF20031", "Invalid nextPage Input.")
+			return
+		}
+		offset = n
+	}
+
+	s.mu.Lock()
+	t := s.tenant(tenantID)
+	if sub := t.subscriptions[ct]; sub == nil || !sub.enabled {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "AF20022", "No subscription found for the specified content type.")
+		return
+	}
+	var blobs []*blob
+	for _, b := range t.blobs {
+		if b.contentType == ct && !b.created.Before(start) && b.created.Before(end) && b.expiration.After(now) {
+			blobs = append(blobs, b)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		if blobs[i].created.Equal(blobs[j].created) {
+			return blobs[i].id < blobs[j].id
+		}
+		return blobs[i].created.Before(blobs[j].created)
+	})
+	out := []contentJSON{}
+	for i := offset; i < len(blobs) && i < offset+s.pageSize; i++ {
+		out = append(out, s.content(tenantID, blobs[i]))
+	}
+	s.mu.Unlock()
+
+	if next := offset + s.pageSize; next < len(blobs) {
+		params.Set("nextpage", "page"+strconv.Itoa(next))
+		nextURL := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: params.Encode()}
+		w.Header().Set("NextPageUri", nextURL.String())
+	}
+	writeJSON(w, out)
+}
+
+func (s *Server) fetchAudit(w http.ResponseWriter, tenantID, contentID string) {
+	s.mu.Lock()
+	b, ok := s.tenant(tenantID).blobs[contentID]
+	expired := ok && !b.expiration.After(s.now())
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "AF20050", fmt.Sprintf("The specified content (%s) doesn't exist.", contentID))
+	case expired:
+		writeError(w, http.StatusBadRequest, "AF20051", fmt.Sprintf("Content requested with the key %s has already expired.", contentID))
+	default:
+		writeJSON(w, b.records)
+	}
+}
+
+// validateWebhook sends the validation notification to the webhook,
+// which must answer with a 200 status.
+func (s *Server) validateWebhook(wh *webhook) bool {
+	code := fmt.Sprintf("validation-%d", time.Now().UnixNano())
+	data, _ := json.Marshal(map[string]string{"validationCode": code})
+	req, err := http.NewRequest(http.MethodPost, wh.Address, bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Webhook-ValidationCode", code)
+	if wh.AuthID != "" {
+		req.Header.Set("Webhook-AuthID", wh.AuthID)
+	}
+	resp, err := s.webhooks.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// notify sends a content notification to the webhook.
+func (s *Server) notify(wh *webhook, content []contentJSON) {
+	data, _ := json.Marshal(content)
+	req, err := http.NewRequest(http.MethodPost, wh.Address, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if wh.AuthID != "" {
+		req.Header.Set("Webhook-AuthID", wh.AuthID)
+	}
+	resp, err := s.webhooks.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
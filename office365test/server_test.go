@@ -0,0 +1,248 @@
+package office365test_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	office365 "github.com/orlangure/go-office365"
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func newClient(s *office365test.Server, tenantID string) *office365.Client {
+	client := office365.NewClient(nil, tenantID, "")
+	client.BaseURL = s.BaseURL()
+	client.RetryPolicy = office365.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	return client
+}
+
+func TestServerContentPaging(t *testing.T) {
+	s := office365test.NewServer(office365test.WithPageSize(2))
+	defer s.Close()
+
+	now := time.Now()
+	s.StartSubscription("tenant", schema.AuditGeneral)
+	ids := s.GenerateContent("tenant", schema.AuditGeneral, now.Add(-time.Hour), now.Add(-10*time.Minute), 10*time.Minute, 1)
+	if len(ids) != 5 {
+		t.Fatalf("got %d blobs but want 5", len(ids))
+	}
+	s.Throttle(office365test.OperationContentList, 1)
+
+	client := newClient(s, "tenant")
+	ct := schema.AuditGeneral
+	responses, content, err := client.Content.List(context.Background(), &ct, now.Add(-2*time.Hour), now)
+	if err != nil {
+		t.Fatalf("error occurred running Content.List: %v", err)
+	}
+	if len(responses) != 3 || len(content) != 5 {
+		t.Fatalf("got %d pages and %d blobs but want 3 pages and 5 blobs", len(responses), len(content))
+	}
+	for i, c := range content {
+		if c.ContentID != ids[i] || c.ContentURI != s.ContentURI("tenant", ids[i]) {
+			t.Errorf("blob %d: got %+v but want contentId %s", i, c, ids[i])
+		}
+	}
+	if got := s.Requests(office365test.OperationContentList); got != 4 {
+		t.Errorf("got %d content requests but want 4", got)
+	}
+
+	_, records, err := client.Audit.ListURI(context.Background(), content[0].ContentURI, false)
+	if err != nil {
+		t.Fatalf("error occurred running Audit.ListURI: %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records but want 1", len(records))
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	now := time.Now()
+	clock := now
+	s := office365test.NewServer(
+		office365test.WithClock(func() time.Time { return clock }),
+		office365test.WithContentTTL(time.Hour),
+	)
+	defer s.Close()
+
+	client := newClient(s, "tenant")
+	ct := schema.AuditExchange
+
+	if _, _, err := client.Content.List(context.Background(), &ct, time.Time{}, time.Time{}); !errors.Is(err, office365.ErrSubscriptionNotEnabled) {
+		t.Errorf("got error %v but want %v", err, office365.ErrSubscriptionNotEnabled)
+	}
+
+	s.StartSubscription("tenant", ct)
+	id, err := s.AddContent("tenant", ct, now.Add(-30*time.Minute), map[string]string{"Id": "record"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Audit.List(context.Background(), "unknown", false); !errors.Is(err, office365.ErrContentNotFound) {
+		t.Errorf("got error %v but want %v", err, office365.ErrContentNotFound)
+	}
+
+	clock = now.Add(time.Hour)
+	if _, _, err := client.Audit.List(context.Background(), id, false); !errors.Is(err, office365.ErrContentExpired) {
+		t.Errorf("got error %v but want %v", err, office365.ErrContentExpired)
+	}
+	_, content, err := client.Content.List(context.Background(), &ct, time.Time{}, time.Time{})
+	if err != nil || len(content) != 0 {
+		t.Errorf("got %d blobs and error %v but want expired content not to be listed", len(content), err)
+	}
+
+	s.Inject(office365test.Fault{Operation: office365test.OperationSubscriptionList, StatusCode: http.StatusForbidden, Code: "AF10001"})
+	if _, _, err := client.Subscription.List(context.Background()); !errors.Is(err, office365.ErrPermissionDenied) {
+		t.Errorf("got error %v but want %v", err, office365.ErrPermissionDenied)
+	}
+}
+
+func TestServerTimeWindow(t *testing.T) {
+	s := office365test.NewServer()
+	defer s.Close()
+	s.StartSubscription("tenant", schema.AuditGeneral)
+
+	now := time.Now().UTC()
+	cases := []struct {
+		Start, End string
+		WantStatus int
+	}{
+		{now.Add(-time.Hour).Format(office365.RequestDatetimeFormat), now.Format(office365.RequestDatetimeFormat), http.StatusOK},
+		{now.Add(-time.Hour).Format(office365.RequestDatetimeFormat), "", http.StatusBadRequest},
+		{now.Add(-25 * time.Hour).Format(office365.RequestDatetimeFormat), now.Format(office365.RequestDatetimeFormat), http.StatusBadRequest},
+		{now.Add(-8 * 24 * time.Hour).Format(office365.RequestDatetimeFormat), now.Add(-7 * 24 * time.Hour).Format(office365.RequestDatetimeFormat), http.StatusBadRequest},
+		{now.Format(office365.RequestDatetimeFormat), now.Add(-time.Hour).Format(office365.RequestDatetimeFormat), http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		u := s.URL + "/api/v1.0/tenant/activity/feed/subscriptions/content?contentType=Audit.General&startTime=" + tc.Start + "&endTime=" + tc.End
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.WantStatus {
+			t.Errorf("window [%s, %s]: got status %d but want %d", tc.Start, tc.End, resp.StatusCode, tc.WantStatus)
+		}
+	}
+}
+
+func TestServerWebhook(t *testing.T) {
+	notifications := make(chan []office365.Content, 1)
+	validated := false
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Webhook-AuthID") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Webhook-ValidationCode") != "" {
+			validated = true
+			return
+		}
+		var content []office365.Content
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			t.Error(err)
+		}
+		notifications <- content
+	}))
+	defer hook.Close()
+
+	s := office365test.NewServer()
+	defer s.Close()
+	client := newClient(s, "tenant")
+
+	ct := schema.AuditSharePoint
+	if _, _, err := client.Subscription.Start(context.Background(), &ct, &office365.Webhook{Address: office365.String(hook.URL)}); !errors.Is(err, office365.ErrWebhookValidationFailed) {
+		t.Errorf("got error %v but want %v", err, office365.ErrWebhookValidationFailed)
+	}
+	_, sub, err := client.Subscription.Start(context.Background(), &ct, &office365.Webhook{Address: office365.String(hook.URL), AuthID: office365.String("secret")})
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+	if !validated || *sub.Status != "enabled" || *sub.Webhook.Address != hook.URL {
+		t.Errorf("got subscription %+v after validation %v", sub, validated)
+	}
+
+	id, err := s.AddContent("tenant", ct, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case content := <-notifications:
+		if len(content) != 1 || content[0].ContentID != id || content[0].ContentType != ct.String() {
+			t.Errorf("got notification %+v but want content %s", content, id)
+		}
+	default:
+		t.Error("webhook was not notified")
+	}
+
+	s.DisableWebhook("tenant", ct)
+	_, subs, err := client.Subscription.List(context.Background())
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	if len(subs) != 1 || subs[0].Webhook == nil || *subs[0].Webhook.Status != "disabled" {
+		t.Errorf("got subscriptions %+v but want a disabled webhook", subs)
+	}
+	if _, err := s.AddContent("tenant", ct, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case content := <-notifications:
+		t.Errorf("disabled webhook was notified of %+v", content)
+	default:
+	}
+}
+
+type chanHandler chan office365.ResourceAudits
+
+func (h chanHandler) Handle(in <-chan office365.ResourceAudits) error {
+	for res := range in {
+		h <- res
+	}
+	return nil
+}
+
+func TestServerWatcher(t *testing.T) {
+	s := office365test.NewServer(office365test.WithPageSize(3))
+	defer s.Close()
+
+	now := time.Now()
+	s.StartSubscription("tenant", schema.AuditGeneral)
+	s.StartSubscription("tenant", schema.AuditExchange)
+	s.GenerateContent("tenant", schema.AuditGeneral, now.Add(-50*time.Minute), now.Add(-5*time.Minute), 5*time.Minute, 2)
+	s.GenerateContent("tenant", schema.AuditExchange, now.Add(-50*time.Minute), now.Add(-5*time.Minute), 15*time.Minute, 3)
+	s.Throttle(office365test.OperationAuditFetch, 2)
+
+	handler := make(chanHandler, 100)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := office365.SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 1}
+	watcher, err := office365.NewSubscriptionWatcher(newClient(s, "tenant"), conf, office365.NewMemoryState(), handler, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = watcher.Run(ctx) }()
+
+	want := 9*2 + 3*3
+	records := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(records) < want {
+		select {
+		case res := <-handler:
+			record, ok := res.AuditRecord.(schema.AuditRecord)
+			if !ok || record.ID == nil {
+				t.Fatalf("got unexpected record %#v", res.AuditRecord)
+			}
+			records[*record.ID] = true
+		case <-timeout:
+			t.Fatalf("got %d records but want %d", len(records), want)
+		}
+	}
+}
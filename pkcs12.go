@@ -0,0 +1,38 @@
+package office365
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// error definition.
+var (
+	ErrPKCS12Password    = errors.New("pkcs12: decryption password incorrect")
+	ErrPKCS12Unsupported = errors.New("pkcs12: unsupported format")
+)
+
+// ParsePKCS12 reads a certificate and its private key from a PKCS#12 (.pfx, .p12) archive.
+// Both the PBES2 (AES) encryption of current OpenSSL versions and the legacy
+// pbeWithSHAAnd3-KeyTripleDES-CBC scheme are supported.
+func ParsePKCS12(data []byte, password string) (*ClientCertificate, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		var notImplemented pkcs12.NotImplementedError
+		switch {
+		case errors.Is(err, pkcs12.ErrIncorrectPassword):
+			return nil, ErrPKCS12Password
+		case errors.As(err, &notImplemented):
+			return nil, fmt.Errorf("%w: %v", ErrPKCS12Unsupported, err)
+		}
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("pkcs12: unsupported key type %T", key)
+	}
+	return matchCertificate(append([]*x509.Certificate{cert}, chain...), signer)
+}
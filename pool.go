@@ -0,0 +1,262 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// error definition.
+var (
+	ErrTenantExists   = errors.New("tenant is already registered")
+	ErrTenantUnknown  = errors.New("tenant is not registered")
+	ErrPoolRunning    = errors.New("pool is already running")
+	ErrPoolTerminated = errors.New("pool is terminated")
+)
+
+var defaultTenantBufferSize = 100
+
+// TenantPoolConfig .
+type TenantPoolConfig struct {
+	// Watcher is the configuration shared by every tenant watcher.
+	Watcher SubscriptionWatcherConfig
+	// PubIdentifier is used by every tenant client.
+	// Each tenant uses its own tenantID if empty.
+	PubIdentifier string
+	// ClientOptions are applied to every tenant client created by the pool.
+	// As the same option values are applied to every client, tenants sharing a
+	// PubIdentifier share the RateLimiter set by WithRateLimit or WithRateLimiters.
+	ClientOptions []ClientOption
+	// NewClient returns the Client of a tenant.
+	// NewClientAuthenticated is used if nil.
+	NewClient func(c *Credentials) *Client
+	// NewState returns the State of a tenant.
+	// NewMemoryState is used if nil.
+	NewState func(tenantID string) State
+	// BufferSize is the number of records a tenant can have waiting for the handler.
+	BufferSize int
+}
+
+// TenantPool runs a SubscriptionWatcher per tenant and merges their records
+// into a single ResourceHandler.
+// Records are tagged with the tenantID they belong to.
+// Each tenant has its own State and its own bounded buffer, so that a tenant
+// producing lots of records, or failing, does not hold the others back.
+// Like a SubscriptionWatcher, a failing tenant logs its errors and is polled
+// again on its next tick.
+type TenantPool struct {
+	config  TenantPoolConfig
+	handler ResourceHandler
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	tenants map[string]*poolTenant
+	ctx     context.Context
+	closed  bool
+	wg      sync.WaitGroup
+	out     chan ResourceAudits
+}
+
+// poolTenant holds the resources of a single tenant.
+type poolTenant struct {
+	id      string
+	watcher *SubscriptionWatcher
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewTenantPool returns a new TenantPool sending the records of every tenant to the provided handler.
+func NewTenantPool(conf TenantPoolConfig, h ResourceHandler, l *slog.Logger) *TenantPool {
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = defaultTenantBufferSize
+	}
+	if conf.NewClient == nil {
+		pubIdentifier, opts := conf.PubIdentifier, conf.ClientOptions
+		conf.NewClient = func(c *Credentials) *Client {
+			return NewClientAuthenticated(c, pubIdentifier, opts...)
+		}
+	}
+	if conf.NewState == nil {
+		conf.NewState = func(string) State { return NewMemoryState() }
+	}
+	return &TenantPool{
+		config:  conf,
+		handler: h,
+		logger:  loggerOrDefault(l),
+		tenants: make(map[string]*poolTenant),
+		out:     make(chan ResourceAudits),
+	}
+}
+
+// Add registers a tenant. If the pool is running, its watcher is started right away.
+func (p *TenantPool) Add(c *Credentials) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolTerminated
+	}
+	if _, ok := p.tenants[c.TenantID]; ok {
+		return fmt.Errorf("%w: %s", ErrTenantExists, c.TenantID)
+	}
+
+	client := p.config.NewClient(c)
+	handler := &tenantHandler{out: p.out, buffer: p.config.BufferSize}
+	watcher, err := NewSubscriptionWatcher(client, p.config.Watcher, p.config.NewState(c.TenantID), handler, p.logger)
+	if err != nil {
+		return err
+	}
+	t := &poolTenant{id: c.TenantID, watcher: watcher}
+	p.tenants[t.id] = t
+
+	if p.ctx != nil {
+		p.start(t)
+	}
+	return nil
+}
+
+// Remove stops the watcher of a tenant and unregisters it.
+// It returns once the watcher is stopped.
+func (p *TenantPool) Remove(tenantID string) error {
+	p.mu.Lock()
+	t, ok := p.tenants[tenantID]
+	if ok {
+		delete(p.tenants, tenantID)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTenantUnknown, tenantID)
+	}
+	if t.cancel != nil {
+		t.cancel()
+		<-t.done
+	}
+	return nil
+}
+
+// Tenants returns the registered tenantIDs, sorted.
+func (p *TenantPool) Tenants() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]string, 0, len(p.tenants))
+	for id := range p.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// State returns the State of a registered tenant.
+func (p *TenantPool) State(tenantID string) (State, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.tenants[tenantID]
+	if !ok {
+		return nil, false
+	}
+	return t.watcher.State, true
+}
+
+// Run starts the watchers of every registered tenant and blocks until
+// the context is done, or the handler returns, and every watcher is stopped.
+// It returns the error returned by the handler.
+// The pool is terminated once Run returns.
+func (p *TenantPool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolTerminated
+	}
+	if p.ctx != nil {
+		p.mu.Unlock()
+		return ErrPoolRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.ctx = ctx
+	for _, t := range p.tenants {
+		p.start(t)
+	}
+	p.mu.Unlock()
+
+	// this goroutine is responsible for closing output channel
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		p.wg.Wait()
+		close(p.out)
+	}()
+
+	err := p.handler.Handle(p.out)
+
+	// the handler may return early, the records left are drained
+	// for the watchers blocked on the output channel to stop
+	cancel()
+	go func() {
+		for range p.out {
+		}
+	}()
+	<-stopped
+
+	p.mu.Lock()
+	p.ctx = nil
+	p.mu.Unlock()
+	return err
+}
+
+// start runs the tenant watcher in its own goroutine until the pool or the tenant is stopped.
+// It must be called with p.mu held.
+func (p *TenantPool) start(t *poolTenant) {
+	ctx, cancel := context.WithCancel(p.ctx)
+	t.cancel = cancel
+	t.done = make(chan struct{})
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(t.done)
+		defer cancel()
+
+		tLogger := p.logger.With("tenant-id", t.id)
+		tLogger.Info("starting tenant watcher")
+		if err := t.watcher.Run(ctx); err != nil {
+			tLogger.Error("tenant watcher stopped", "error", err)
+			return
+		}
+		tLogger.Info("tenant watcher stopped")
+	}()
+}
+
+// tenantHandler forwards the records of a tenant to the pool output channel
+// through a bounded buffer.
+// Senders blocked on a channel are served in order, so tenants take turns
+// on the shared output channel once their buffers are full.
+type tenantHandler struct {
+	out    chan<- ResourceAudits
+	buffer int
+}
+
+// Handle implements the ResourceHandler interface.
+func (h *tenantHandler) Handle(in <-chan ResourceAudits) error {
+	buf := make(chan ResourceAudits, h.buffer)
+	go func() {
+		defer close(buf)
+		for res := range in {
+			buf <- res
+		}
+	}()
+	for res := range buf {
+		h.out <- res
+	}
+	return nil
+}
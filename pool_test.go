@@ -0,0 +1,189 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tenantsServer fakes the API for any tenant: every tenant has a single
+// Audit.General blob holding a single record identified by the tenantID.
+// Tenants listed in failing get a 500 on every request.
+func tenantsServer(failing ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /api/{version}/{tenantID}/activity/feed/{operation}
+		tokens := strings.SplitN(r.URL.Path, "/", 7)
+		if len(tokens) != 7 {
+			http.NotFound(w, r)
+			return
+		}
+		tenantID, operation := tokens[3], tokens[6]
+		for _, f := range failing {
+			if f == tenantID {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		switch {
+		case operation == "subscriptions/list":
+			fmt.Fprint(w, `[{"contentType": "Audit.General", "status": "enabled"}]`)
+		case operation == "subscriptions/content":
+			created := time.Now().Add(-time.Minute).Format(CreatedDatetimeFormat)
+			fmt.Fprintf(w, `[{"contentType": "Audit.General", "contentId": "%s-blob", "contentCreated": "%s"}]`, tenantID, created)
+		case strings.HasPrefix(operation, "audit/"):
+			fmt.Fprintf(w, `[{"Id": "%s-record", "RecordType": 1}]`, tenantID)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+type chanHandler struct {
+	ch chan ResourceAudits
+}
+
+func (h chanHandler) Handle(in <-chan ResourceAudits) error {
+	for res := range in {
+		h.ch <- res
+	}
+	return nil
+}
+
+func waitTenantRecord(t *testing.T, ch <-chan ResourceAudits, tenantID string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case res := <-ch:
+			if res.TenantID == tenantID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no record received for tenant %s", tenantID)
+		}
+	}
+}
+
+func TestTenantPool(t *testing.T) {
+	server := tenantsServer("tenant-failing")
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	conf := TenantPoolConfig{
+		Watcher: SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 1},
+		NewClient: func(c *Credentials) *Client {
+			client := NewClient(nil, c.TenantID, "")
+			client.BaseURL = baseURL
+			client.RetryPolicy = testRetryPolicy()
+			return client
+		},
+	}
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	pool := NewTenantPool(conf, handler, logger)
+
+	for _, id := range []string{"tenant-a", "tenant-failing"} {
+		if err := pool.Add(&Credentials{TenantID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Add(&Credentials{TenantID: "tenant-a"}); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("got error %v but want %v", err, ErrTenantExists)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- pool.Run(ctx) }()
+
+	waitTenantRecord(t, handler.ch, "tenant-a")
+
+	// tenants can be added while the pool is running
+	if err := pool.Add(&Credentials{TenantID: "tenant-b"}); err != nil {
+		t.Fatal(err)
+	}
+	waitTenantRecord(t, handler.ch, "tenant-b")
+
+	if err := pool.Remove("tenant-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Remove("tenant-a"); !errors.Is(err, ErrTenantUnknown) {
+		t.Errorf("got error %v but want %v", err, ErrTenantUnknown)
+	}
+	testDeep(t, pool.Tenants(), []string{"tenant-b", "tenant-failing"})
+
+	if _, ok := pool.State("tenant-b"); !ok {
+		t.Errorf("no state found for tenant-b")
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("error occurred running TenantPool.Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool did not stop")
+	}
+	if err := pool.Add(&Credentials{TenantID: "tenant-c"}); !errors.Is(err, ErrPoolTerminated) {
+		t.Errorf("got error %v but want %v", err, ErrPoolTerminated)
+	}
+}
+
+// errHandler returns its error right away.
+type errHandler struct {
+	err error
+}
+
+func (h errHandler) Handle(<-chan ResourceAudits) error {
+	return h.err
+}
+
+func TestTenantPoolHandlerError(t *testing.T) {
+	server := tenantsServer()
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := TenantPoolConfig{
+		Watcher: SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 1},
+		NewClient: func(c *Credentials) *Client {
+			client := NewClient(nil, c.TenantID, "")
+			client.BaseURL = baseURL
+			return client
+		},
+	}
+	handlerErr := errors.New("handler failed")
+	pool := NewTenantPool(conf, errHandler{err: handlerErr}, logger)
+	if err := pool.Add(&Credentials{TenantID: "tenant-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error)
+	go func() { errCh <- pool.Run(context.Background()) }()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, handlerErr) {
+			t.Errorf("got error %v but want %v", err, handlerErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool did not stop with its handler")
+	}
+
+	// the tenant watchers are stopped
+	if err := pool.Remove("tenant-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Run(context.Background()); !errors.Is(err, ErrPoolTerminated) {
+		t.Errorf("got error %v but want %v", err, ErrPoolTerminated)
+	}
+}
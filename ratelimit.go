@@ -0,0 +1,169 @@
+package office365
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit describes a token bucket.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#api-throttling
+type RateLimit struct {
+	// RequestsPerSecond is the rate at which the budget refills.
+	RequestsPerSecond float64
+	// Burst is the maximum budget, which is also the initial one.
+	Burst int
+}
+
+// RateLimiter is a token bucket limiting the rate of requests sent to the API.
+// A request consumes one token, and waits for it if the budget is exhausted.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with a full budget.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// RateLimiters holds a RateLimiter per PublisherIdentifier, all created with the same limit.
+// The clients it is passed to share the RateLimiter of their PublisherIdentifier.
+type RateLimiters struct {
+	limit RateLimit
+
+	mu sync.Mutex
+	m  map[string]*RateLimiter
+}
+
+// NewRateLimiters returns a RateLimiters creating its limiters with the provided limit.
+func NewRateLimiters(limit RateLimit) *RateLimiters {
+	return &RateLimiters{
+		limit: limit,
+		m:     make(map[string]*RateLimiter),
+	}
+}
+
+// For returns the RateLimiter of the provided PublisherIdentifier,
+// creating it on first use.
+func (r *RateLimiters) For(pubIdentifier string) *RateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.m[pubIdentifier]
+	if !ok {
+		l = NewRateLimiter(r.limit)
+		r.m[pubIdentifier] = l
+	}
+	return l
+}
+
+// WithRateLimiters makes every request of the client go through the RateLimiter
+// of its PublisherIdentifier, shared with the other clients using r.
+func WithRateLimiters(r *RateLimiters) ClientOption {
+	return func(c *Client) {
+		c.limiters = r
+	}
+}
+
+// WithRateLimit makes every request of the client go through a RateLimiter.
+// Each call creates its own RateLimiters: the clients created with the same option
+// value share the RateLimiter of their PublisherIdentifier, while clients created
+// with separate calls are limited separately, even with the same limit and
+// PublisherIdentifier. Use WithRateLimiters to share limiters in other ways.
+func WithRateLimit(limit RateLimit) ClientOption {
+	return WithRateLimiters(NewRateLimiters(limit))
+}
+
+// RateLimiter returns the RateLimiter used by the client, nil if requests are not limited.
+func (c *Client) RateLimiter() *RateLimiter {
+	return c.rateLimiter(c.pubIdentifier)
+}
+
+// rateLimiter returns the RateLimiter of the provided PublisherIdentifier, nil if requests are not limited.
+func (c *Client) rateLimiter(pubIdentifier string) *RateLimiter {
+	if c.limiters == nil {
+		return nil
+	}
+	return c.limiters.For(pubIdentifier)
+}
+
+// refill adds the tokens accumulated since the last call. It must be called with l.mu held.
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last)
+	if elapsed <= 0 {
+		return
+	}
+	l.last = now
+	l.tokens += elapsed.Seconds() * l.limit.RequestsPerSecond
+	if burst := float64(l.limit.Burst); l.tokens > burst {
+		l.tokens = burst
+	}
+}
+
+// Wait blocks until a token is available or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.limit.RequestsPerSecond <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens--
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-l.tokens / l.limit.RequestsPerSecond * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// give the reserved token back
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Budget returns the number of requests that can be sent right away.
+// It is negative when requests are waiting for tokens.
+func (l *RateLimiter) Budget() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	return l.tokens
+}
+
+// Limit returns the current limit.
+func (l *RateLimiter) Limit() RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// SetLimit changes the limit. The current budget is kept, within the new burst.
+func (l *RateLimiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.limit = limit
+	if burst := float64(limit.Burst); l.tokens > burst {
+		l.tokens = burst
+	}
+}
@@ -0,0 +1,116 @@
+package office365
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(RateLimit{RequestsPerSecond: 20, Burst: 2})
+	if got := l.Budget(); got != 2 {
+		t.Errorf("got budget %v but want 2", got)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst is consumed right away, the third request waits for 1/20s
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("requests were not limited, took %v", elapsed)
+	}
+
+	// an exhausted budget makes Wait honor the context
+	l.SetLimit(RateLimit{RequestsPerSecond: 0.001, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = l.Wait(ctx)
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v but want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	limit := RateLimit{RequestsPerSecond: 1, Burst: 5}
+	opt := WithRateLimit(limit)
+	a := NewClient(nil, "tenant-a", "test-shared-publisher", opt)
+	b := NewClient(nil, "tenant-b", "test-shared-publisher", opt)
+	c := NewClient(nil, "tenant-c", "", opt)
+	d := NewClient(nil, "tenant-d", "")
+	e := NewClient(nil, "tenant-e", "test-shared-publisher", WithRateLimit(limit))
+
+	if a.RateLimiter() != b.RateLimiter() {
+		t.Errorf("clients using the same publisher identifier must share the limiter")
+	}
+	if a.RateLimiter() == c.RateLimiter() {
+		t.Errorf("clients using different publisher identifiers must not share the limiter")
+	}
+	if d.RateLimiter() != nil {
+		t.Errorf("clients are not limited by default")
+	}
+	if a.RateLimiter() == e.RateLimiter() {
+		t.Errorf("clients using other limiters must not share the limiter")
+	}
+
+	// the clients of a pool are created with the same option values
+	pool := NewTenantPool(TenantPoolConfig{
+		Watcher:       SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5},
+		PubIdentifier: "test-shared-publisher",
+		ClientOptions: []ClientOption{WithRateLimit(limit)},
+	}, nil, nil)
+	for _, id := range []string{"tenant-a", "tenant-b"} {
+		if err := pool.Add(&Credentials{TenantID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pa, pb := pool.tenants["tenant-a"].watcher.client, pool.tenants["tenant-b"].watcher.client
+	if pa.RateLimiter() == nil || pa.RateLimiter() != pb.RateLimiter() {
+		t.Errorf("tenants of a pool using the same publisher identifier must share the limiter")
+	}
+}
+
+func TestRateLimiterClient(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.limiters = NewRateLimiters(RateLimit{RequestsPerSecond: 0.001, Burst: 3})
+
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.Subscription.List(context.Background()); err != nil {
+			t.Fatalf("error occurred running Subscriptions.List: %v", err)
+		}
+	}
+	if got := client.RateLimiter().Budget(); got < 1 || got >= 1.1 {
+		t.Errorf("got budget %v but want 1", got)
+	}
+}
+
+func TestRateLimiterPubIdentifierOverride(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.limiters = NewRateLimiters(RateLimit{RequestsPerSecond: 0.001, Burst: 3})
+
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	if _, _, err := client.Subscription.List(context.Background(), WithPubIdentifier("other-publisher")); err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	if got := client.rateLimiter("other-publisher").Budget(); got < 2 || got >= 2.1 {
+		t.Errorf("got budget %v for the overriding publisher but want 2", got)
+	}
+	if got := client.RateLimiter().Budget(); got < 3 {
+		t.Errorf("got budget %v for the client publisher but want 3", got)
+	}
+}
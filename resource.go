@@ -5,9 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 // ResourceHandler is an interface for handling streamed resources.
@@ -19,39 +18,49 @@ type ResourceHandler interface {
 // It writes json representation of a resource on the provided writer.
 type JSONHandler struct {
 	writer io.Writer
-	logger *logrus.Logger
+	logger *slog.Logger
 	indent bool
 }
 
 // NewJSONHandler returns a JSONHandler using the provided writer.
-func NewJSONHandler(w io.Writer, l *logrus.Logger, indent bool) *JSONHandler {
-	return &JSONHandler{w, l, indent}
+// The default slog logger is used if l is nil.
+func NewJSONHandler(w io.Writer, l *slog.Logger, indent bool) *JSONHandler {
+	return &JSONHandler{w, loggerOrDefault(l), indent}
 }
 
 // Handle .
+// Records are acknowledged once written, see ResourceAudits.Ack; records which
+// cannot be encoded are acknowledged too, as they would fail again.
+// Records which cannot be written are not, so that they are emitted again.
 func (h JSONHandler) Handle(in <-chan ResourceAudits) error {
 	for res := range in {
 		record := &JSONRecord{
 			ContentType: res.ContentType.String(),
 			RequestTime: res.RequestTime,
+			TenantID:    res.TenantID,
 			Record:      res.AuditRecord,
 		}
 		recordStr, err := json.Marshal(record)
 		if err != nil {
-			h.logger.Error(err)
+			h.logger.Error("could not marshal record", "content-type", record.ContentType, "error", err)
+			res.Ack()
 			continue
 		}
-		if !h.indent {
-			fmt.Fprintln(h.writer, string(recordStr))
-			continue
+		if h.indent {
+			var out bytes.Buffer
+			err = json.Indent(&out, recordStr, "", "\t")
+			if err != nil {
+				h.logger.Error("could not indent record", "content-type", record.ContentType, "error", err)
+				res.Ack()
+				continue
+			}
+			recordStr = out.Bytes()
 		}
-		var out bytes.Buffer
-		err = json.Indent(&out, recordStr, "", "\t")
-		if err != nil {
-			h.logger.Error(err)
+		if _, err := fmt.Fprintln(h.writer, string(recordStr)); err != nil {
+			h.logger.Error("could not write record", "content-type", record.ContentType, "error", err)
 			continue
 		}
-		fmt.Fprintln(h.writer, out.String())
+		res.Ack()
 	}
 	return nil
 }
@@ -60,5 +69,6 @@ func (h JSONHandler) Handle(in <-chan ResourceAudits) error {
 type JSONRecord struct {
 	ContentType string
 	RequestTime time.Time
+	TenantID    string `json:",omitempty"`
 	Record      interface{}
 }
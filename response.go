@@ -0,0 +1,100 @@
+package office365
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ResponseMeta holds the metadata of a response, as needed by Microsoft support
+// for investigating a call.
+type ResponseMeta struct {
+	// RequestID is the identifier Microsoft gave to the request.
+	RequestID string
+	// ClientRequestID is the identifier sent by the client, see RequestIDInterceptor.
+	ClientRequestID string
+	// Date is the time the response was generated, zero if unknown.
+	Date time.Time
+	// RetryAfter is the delay the API asked to wait before retrying, zero if none.
+	RetryAfter time.Duration
+	// NextPage is the token of the next page of content, empty on the last page.
+	NextPage string
+	// Attempts is the number of attempts it took to get the response.
+	Attempts int
+	// Duration is the time it took to get the response, retries included.
+	Duration time.Duration
+}
+
+// newResponseMeta returns the metadata found in the response headers.
+func newResponseMeta(r *http.Response) ResponseMeta {
+	meta := ResponseMeta{
+		RequestID:       r.Header.Get("request-id"),
+		ClientRequestID: r.Header.Get("client-request-id"),
+	}
+	if meta.ClientRequestID == "" && r.Request != nil {
+		meta.ClientRequestID = r.Request.Header.Get("client-request-id")
+	}
+	if date, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+		meta.Date = date
+	}
+	if retryAfter, ok := parseRetryAfter(r.Header, time.Now()); ok {
+		meta.RetryAfter = retryAfter
+	}
+	if nextPage, err := nextPageToken(r.Header); err == nil {
+		meta.NextPage = nextPage
+	}
+	return meta
+}
+
+// errorDetails returns the metadata to append to error strings.
+func (m ResponseMeta) errorDetails() string {
+	var details []string
+	if m.RequestID != "" {
+		details = append(details, "request-id: "+m.RequestID)
+	}
+	if m.ClientRequestID != "" {
+		details = append(details, "client-request-id: "+m.ClientRequestID)
+	}
+	if !m.Date.IsZero() {
+		details = append(details, "date: "+m.Date.UTC().Format(time.RFC1123))
+	}
+	if m.RetryAfter > 0 {
+		details = append(details, "retry-after: "+m.RetryAfter.String())
+	}
+	if m.Attempts > 0 {
+		details = append(details, fmt.Sprintf("attempts: %d", m.Attempts))
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+// LogValue implements slog.LogValuer, logging the error along with its metadata as separate fields.
+func (r *ErrorResponse) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("msg", r.Error()),
+		slog.Int("status", r.Response.StatusCode),
+	}
+	if code := r.Code(); code != "" {
+		attrs = append(attrs, slog.String("code", code))
+	}
+	if r.RequestID != "" {
+		attrs = append(attrs, slog.String("request-id", r.RequestID))
+	}
+	if r.ClientRequestID != "" {
+		attrs = append(attrs, slog.String("client-request-id", r.ClientRequestID))
+	}
+	if !r.Date.IsZero() {
+		attrs = append(attrs, slog.Time("date", r.Date))
+	}
+	if r.RetryAfter > 0 {
+		attrs = append(attrs, slog.Duration("retry-after", r.RetryAfter))
+	}
+	if r.Attempts > 0 {
+		attrs = append(attrs, slog.Int("attempts", r.Attempts), slog.Duration("duration", r.Duration))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,96 @@
+package office365
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestResponseMeta(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	url := client.getURL("subscriptions/content", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("request-id", "server-id")
+		w.Header().Set("client-request-id", r.Header.Get("client-request-id"))
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+		if r.URL.Query().Get("nextpage") == "" {
+			w.Header().Set("NextPageUri", url.String()+"?nextpage=page-2")
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	ct := schema.AuditGeneral
+	var pages []*ContentPage
+	err := client.Content.ListPages(context.Background(), &ct, time.Time{}, time.Time{}, "", func(page *ContentPage) error {
+		pages = append(pages, page)
+		return nil
+	}, WithHeader("client-request-id", "client-id"))
+	if err != nil {
+		t.Fatalf("error occurred running Content.ListPages: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages but want 2", len(pages))
+	}
+
+	meta := pages[0].Response.ResponseMeta
+	if meta.Duration <= 0 {
+		t.Errorf("got duration %v but want a positive one", meta.Duration)
+	}
+	meta.Duration = 0
+	testDeep(t, meta, ResponseMeta{
+		RequestID:       "server-id",
+		ClientRequestID: "client-id",
+		Date:            date,
+		NextPage:        "page-2",
+		Attempts:        1,
+	})
+	if next := pages[1].Response.NextPage; next != "" {
+		t.Errorf("got next page %q on the last page", next)
+	}
+}
+
+func TestErrorResponseMeta(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	url := client.getURL("audit/abc", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("request-id", "server-id")
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": "AF429", "message": "Too many requests."}}`)
+	})
+
+	_, _, err := client.Audit.List(context.Background(), "abc", false)
+	var errorResponse *ErrorResponse
+	if !errors.As(err, &errorResponse) {
+		t.Fatalf("got error %v but want an *ErrorResponse", err)
+	}
+	if errorResponse.RequestID != "server-id" || errorResponse.RetryAfter != 30*time.Second || errorResponse.Attempts != 1 {
+		t.Errorf("got metadata %+v", errorResponse.ResponseMeta)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "request-id: server-id") || !strings.Contains(msg, "attempts: 1") {
+		t.Errorf("error string does not hold the metadata: %s", msg)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Error("failed", "error", err)
+	for _, want := range []string{"error.status=429", "error.code=AF429", "error.request-id=server-id", "error.retry-after=30s", "error.attempts=1"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log entry does not contain %q: %s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package office365
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how the Client retries requests that were throttled
+// or hit a transient failure on Microsoft side.
+// Only idempotent requests, such as listing content or fetching audits, are retried
+// on transport errors and 5xx responses. Other requests, such as starting a subscription,
+// are retried only when rejected before being processed: throttled with a 429, or
+// with a 503 carrying a Retry-After header.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#api-throttling
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, the first one included.
+	// A value of 1 or less disables retries.
+	MaxAttempts int
+	// MinBackoff is the base delay used for the first retry.
+	// The delay doubles on every following attempt.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff time.Duration
+	// MaxElapsed caps the total time spent on a single call, waits included.
+	// Zero means no limit.
+	MaxElapsed time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by clients created with NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+	MaxElapsed:  2 * time.Minute,
+}
+
+// retryableStatus reports whether a response with the provided status code
+// is worth retrying.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// idempotentMethod reports whether sending a request of the method more than once
+// has the same effect as sending it once.
+func idempotentMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether the attempt which returned resp or err is worth retrying,
+// see RetryPolicy.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if idempotentMethod(req.Method) {
+		return err != nil || retryableStatus(resp.StatusCode)
+	}
+	if err != nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		_, ok := parseRetryAfter(resp.Header, time.Now())
+		return ok
+	}
+	return false
+}
+
+// backoff returns the delay to wait before the provided attempt.
+// It uses exponential backoff with jitter: the result lies between
+// half and the whole of the exponential delay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// parseRetryAfter parses the Retry-After header, which is either
+// a number of seconds or a http date.
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// roundTrip sends the request using the underlying client, retrying
+// according to the client RetryPolicy.
+// Every attempt goes through the RateLimiter of the call PublisherIdentifier, if any,
+// and the client interceptors.
+// It returns the last response received along with the number of attempts made.
+// Request bodies are rewound through req.GetBody before each retry, requests
+// whose body cannot be rewound are sent only once.
+func (c *Client) roundTrip(ctx context.Context, req *http.Request) (*http.Response, int, error) {
+	policy := c.RetryPolicy
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	var deadline time.Time
+	if policy.MaxElapsed > 0 {
+		deadline = time.Now().Add(policy.MaxElapsed)
+	}
+
+	// the call may override the PublisherIdentifier, and so the RateLimiter
+	limiter := c.RateLimiter()
+	if s := callSettingsFromContext(ctx); s != nil {
+		limiter = c.rateLimiter(s.pubIdentifier)
+	}
+	for attempt := 1; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, attempt - 1, err
+			}
+		}
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, attempt - 1, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.send(req)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil, attempt, ctx.Err()
+			default:
+			}
+		}
+
+		if attempt >= policy.MaxAttempts || !rewindable {
+			return resp, attempt, err
+		}
+		if !retryable(req, resp, err) {
+			return resp, attempt, err
+		}
+
+		wait := policy.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header, time.Now()); ok {
+				wait = retryAfter
+			}
+		}
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return resp, attempt, err
+		}
+
+		if resp != nil {
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package office365
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+		MaxElapsed:  time.Second,
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	calls := 0
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `[{"contentType": "Audit.General", "status": "enabled"}]`)
+	})
+
+	resp, subscriptions, err := client.Subscription.List(context.Background())
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	if resp.Attempts != 2 {
+		t.Errorf("got %d attempts but want 2", resp.Attempts)
+	}
+	testDeep(t, subscriptions, []Subscription{{ContentType: String("Audit.General"), Status: String("enabled")}})
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	calls := 0
+	url := client.getURL("subscriptions/start", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var payload struct {
+			Webhook *Webhook `json:"webhook"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("attempt %d: error decoding body: %s", calls, err)
+		}
+		if payload.Webhook == nil || payload.Webhook.Address == nil || *payload.Webhook.Address != "test-address" {
+			t.Errorf("attempt %d: webhook payload was not replayed: %+v", calls, payload.Webhook)
+		}
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"contentType": "Audit.General", "status": "enabled"}`)
+	})
+
+	ct := schema.AuditGeneral
+	resp, _, err := client.Subscription.Start(context.Background(), &ct, &Webhook{Address: String("test-address")})
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+	if resp.Attempts != 2 {
+		t.Errorf("got %d attempts but want 2", resp.Attempts)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	calls := 0
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": "AF429", "message": "Too many requests."}}`)
+	})
+
+	resp, _, err := client.Subscription.List(context.Background())
+	var errResp *ErrorResponse
+	if !errors.As(err, &errResp) {
+		t.Fatalf("got error %v but want *ErrorResponse", err)
+	}
+	if errResp.Err.Error.Code != "AF429" {
+		t.Errorf("got error code %q but want AF429", errResp.Err.Error.Code)
+	}
+	if calls != 3 || resp.Attempts != 3 {
+		t.Errorf("got %d calls and %d attempts but want 3", calls, resp.Attempts)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	calls := 0
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	resp, _, err := client.Subscription.List(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 || resp.Attempts != 1 {
+		t.Errorf("got %d calls and %d attempts but want 1", calls, resp.Attempts)
+	}
+}
+
+func TestRetryNotIdempotent(t *testing.T) {
+	cases := []struct {
+		Status       int
+		RetryAfter   string
+		WantAttempts int
+	}{
+		{http.StatusInternalServerError, "", 1},
+		{http.StatusServiceUnavailable, "", 1},
+		{http.StatusServiceUnavailable, "0", 2},
+		{http.StatusTooManyRequests, "", 2},
+	}
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			client, mux, teardown := stubClient()
+			defer teardown()
+			client.RetryPolicy = testRetryPolicy()
+
+			calls := 0
+			url := client.getURL("subscriptions/start", nil)
+			mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					if c.RetryAfter != "" {
+						w.Header().Set("Retry-After", c.RetryAfter)
+					}
+					w.WriteHeader(c.Status)
+					return
+				}
+				fmt.Fprint(w, `{"contentType": "Audit.General", "status": "enabled"}`)
+			})
+
+			ct := schema.AuditGeneral
+			resp, _, _ := client.Subscription.Start(context.Background(), &ct, nil)
+			if calls != c.WantAttempts || resp.Attempts != c.WantAttempts {
+				t.Errorf("got %d calls and %d attempts but want %d", calls, resp.Attempts, c.WantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+	client.RetryPolicy = testRetryPolicy()
+
+	calls := 0
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	resp, _, err := client.Subscription.List(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 || resp.Attempts != 1 {
+		t.Errorf("got %d calls and %d attempts but want 1", calls, resp.Attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Value  string
+		Want   time.Duration
+		WantOK bool
+	}{
+		{Value: "", Want: 0, WantOK: false},
+		{Value: "5", Want: 5 * time.Second, WantOK: true},
+		{Value: "-1", Want: 0, WantOK: true},
+		{Value: now.Add(time.Minute).Format(http.TimeFormat), Want: time.Minute, WantOK: true},
+		{Value: now.Add(-time.Minute).Format(http.TimeFormat), Want: 0, WantOK: true},
+		{Value: "soon", Want: 0, WantOK: false},
+	}
+
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			h := http.Header{}
+			if c.Value != "" {
+				h.Set("Retry-After", c.Value)
+			}
+			got, ok := parseRetryAfter(h, now)
+			if got != c.Want || ok != c.WantOK {
+				t.Errorf("got (%v, %v) but want (%v, %v)", got, ok, c.Want, c.WantOK)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	cases := []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{Attempt: 1, Max: 100 * time.Millisecond},
+		{Attempt: 2, Max: 200 * time.Millisecond},
+		{Attempt: 3, Max: 400 * time.Millisecond},
+		{Attempt: 10, Max: time.Second},
+	}
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("%d.", idx), func(t *testing.T) {
+			got := p.backoff(c.Attempt)
+			if got < c.Max/2 || got > c.Max {
+				t.Errorf("got %v but want between %v and %v", got, c.Max/2, c.Max)
+			}
+		})
+	}
+}
@@ -1,107 +1,294 @@
 package office365
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/orlangure/go-office365/schema"
 )
 
-// State is an interface for storinm and retrievinm Watcher state.
+// State stores the progress of watchers, so that they resume where they
+// stopped after a restart.
+// Updates may be buffered by the implementation until Flush is called.
+// Implementations must be safe for concurrent use.
+//
+// Read and Write are no longer part of State: code persisting a State with them
+// should keep a *MemoryState, which still has both and reads the former JSON
+// format, or use NewFileState, whose Flush writes the file.
 type State interface {
-	setLastContentCreated(*schema.ContentType, time.Time)
-	getLastContentCreated(*schema.ContentType) time.Time
-	setLastRequestTime(*schema.ContentType, time.Time)
-	getLastRequestTime(*schema.ContentType) time.Time
-	Read(io.Reader) error
-	Write(io.Writer) error
+	// Checkpoint returns the checkpoint of a content type, the zero Checkpoint if none.
+	Checkpoint(ctx context.Context, ct schema.ContentType) (Checkpoint, error)
+	// UpdateCheckpoint atomically replaces the checkpoint of a content type
+	// with the one returned by fn, which is passed the current one.
+	UpdateCheckpoint(ctx context.Context, ct schema.ContentType, fn func(Checkpoint) Checkpoint) error
+	// Flush persists the updates made since the last call.
+	// Watchers call it on every tick and before Run returns.
+	Flush(ctx context.Context) error
 }
 
-// MemoryState is an in-memory State interface implementation.
+// DedupState is a State also remembering the content blobs and the audit records
+// already emitted. It is required by watchers running with Deduplicate, and by
+// WebhookWatcher.
+type DedupState interface {
+	State
+	// SetContentSeen records a content blob as fetched until the provided expiration.
+	SetContentSeen(ctx context.Context, id string, expiration time.Time) error
+	// ContentSeen reports whether a content blob was fetched and has not expired.
+	ContentSeen(ctx context.Context, id string) (bool, error)
+	// SetRecordSeen records an audit record as emitted until the provided expiration.
+	SetRecordSeen(ctx context.Context, id string, expiration time.Time) error
+	// RecordSeen reports whether an audit record was emitted and has not expired.
+	RecordSeen(ctx context.Context, id string) (bool, error)
+}
+
+// Checkpoint is the progress of a watcher on a content type.
+type Checkpoint struct {
+	// LastContentCreated is the creation time of the last content blob emitted.
+	LastContentCreated time.Time
+	// LastRequestTime is the end of the last time window listed.
+	LastRequestTime time.Time
+}
+
+// setLastContentCreated moves the lastContentCreated of a content type forward to t.
+func setLastContentCreated(ctx context.Context, s State, ct schema.ContentType, t time.Time) error {
+	return s.UpdateCheckpoint(ctx, ct, func(c Checkpoint) Checkpoint {
+		if c.LastContentCreated.Before(t) {
+			c.LastContentCreated = t
+		}
+		return c
+	})
+}
+
+// setLastRequestTime moves the lastRequestTime of a content type forward to t.
+func setLastRequestTime(ctx context.Context, s State, ct schema.ContentType, t time.Time) error {
+	return s.UpdateCheckpoint(ctx, ct, func(c Checkpoint) Checkpoint {
+		if c.LastRequestTime.Before(t) {
+			c.LastRequestTime = t
+		}
+		return c
+	})
+}
+
+// MemoryState is an in-memory DedupState interface implementation.
+// It is persisted with Write and restored with Read, or persisted to a file
+// on Flush when created with NewFileState.
 type MemoryState struct {
-	muCreated          *sync.RWMutex
-	lastContentCreated map[schema.ContentType]time.Time
-	muRequest          *sync.RWMutex
-	lastRequestTime    map[schema.ContentType]time.Time
+	path    string
+	muFlush *sync.Mutex
+
+	muCheckpoints *sync.RWMutex
+	checkpoints   map[schema.ContentType]Checkpoint
+	muSeen        *sync.RWMutex
+	seenContent   map[string]time.Time
+	seenRecords   map[string]time.Time
+	seenPruned    time.Time
 }
 
 // NewMemoryState returns a new MemoryState.
 func NewMemoryState() *MemoryState {
 	return &MemoryState{
-		muCreated:          &sync.RWMutex{},
-		lastContentCreated: make(map[schema.ContentType]time.Time),
-		muRequest:          &sync.RWMutex{},
-		lastRequestTime:    make(map[schema.ContentType]time.Time),
+		muFlush:       &sync.Mutex{},
+		muCheckpoints: &sync.RWMutex{},
+		checkpoints:   make(map[schema.ContentType]Checkpoint),
+		muSeen:        &sync.RWMutex{},
+		seenContent:   make(map[string]time.Time),
+		seenRecords:   make(map[string]time.Time),
 	}
 }
 
-func (m *MemoryState) setLastContentCreated(ct *schema.ContentType, t time.Time) {
-	m.muCreated.Lock()
-	defer m.muCreated.Unlock()
+// NewFileState returns a MemoryState written to the file at path on every Flush.
+// The state is restored from the file if it exists.
+func NewFileState(path string) (*MemoryState, error) {
+	m := NewMemoryState()
+	m.path = path
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return m, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
 
-	last, ok := m.lastContentCreated[*ct]
-	if !ok || last.Before(t) {
-		m.lastContentCreated[*ct] = t
+	if err := m.Read(f); err != nil {
+		return nil, fmt.Errorf("reading state %s: %w", path, err)
 	}
+	return m, nil
 }
 
-func (m *MemoryState) getLastContentCreated(ct *schema.ContentType) time.Time {
-	m.muCreated.RLock()
-	defer m.muCreated.RUnlock()
+// Checkpoint implements the State interface.
+func (m *MemoryState) Checkpoint(ctx context.Context, ct schema.ContentType) (Checkpoint, error) {
+	m.muCheckpoints.RLock()
+	defer m.muCheckpoints.RUnlock()
 
-	t, ok := m.lastContentCreated[*ct]
-	if !ok {
-		return time.Time{}
-	}
-	return t
+	return m.checkpoints[ct], nil
 }
 
-func (m *MemoryState) setLastRequestTime(ct *schema.ContentType, t time.Time) {
-	m.muRequest.Lock()
-	defer m.muRequest.Unlock()
+// UpdateCheckpoint implements the State interface.
+func (m *MemoryState) UpdateCheckpoint(ctx context.Context, ct schema.ContentType, fn func(Checkpoint) Checkpoint) error {
+	m.muCheckpoints.Lock()
+	defer m.muCheckpoints.Unlock()
 
-	last, ok := m.lastRequestTime[*ct]
-	if !ok || last.Before(t) {
-		m.lastRequestTime[*ct] = t
-	}
+	m.checkpoints[ct] = fn(m.checkpoints[ct])
+	return nil
+}
+
+// SetContentSeen implements the State interface.
+func (m *MemoryState) SetContentSeen(ctx context.Context, id string, expiration time.Time) error {
+	m.muSeen.Lock()
+	defer m.muSeen.Unlock()
+
+	m.pruneSeen()
+	m.seenContent[id] = expiration
+	return nil
+}
+
+// ContentSeen implements the State interface.
+func (m *MemoryState) ContentSeen(ctx context.Context, id string) (bool, error) {
+	m.muSeen.RLock()
+	defer m.muSeen.RUnlock()
+
+	exp, ok := m.seenContent[id]
+	return ok && exp.After(time.Now()), nil
+}
+
+// SetRecordSeen implements the State interface.
+func (m *MemoryState) SetRecordSeen(ctx context.Context, id string, expiration time.Time) error {
+	m.muSeen.Lock()
+	defer m.muSeen.Unlock()
+
+	m.pruneSeen()
+	m.seenRecords[id] = expiration
+	return nil
 }
 
-func (m *MemoryState) getLastRequestTime(ct *schema.ContentType) time.Time {
-	m.muRequest.RLock()
-	defer m.muRequest.RUnlock()
+// RecordSeen implements the State interface.
+func (m *MemoryState) RecordSeen(ctx context.Context, id string) (bool, error) {
+	m.muSeen.RLock()
+	defer m.muSeen.RUnlock()
 
-	t, ok := m.lastRequestTime[*ct]
-	if !ok {
-		return time.Time{}
+	exp, ok := m.seenRecords[id]
+	return ok && exp.After(time.Now()), nil
+}
+
+// Flush implements the State interface.
+// The state is written to a temporary file renamed over the file of a MemoryState
+// created with NewFileState, so that the file is replaced atomically.
+// It is a no-op otherwise.
+func (m *MemoryState) Flush(ctx context.Context) error {
+	if m.path == "" {
+		return nil
 	}
-	return t
+	m.muFlush.Lock()
+	defer m.muFlush.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), m.path)
+}
+
+// pruneSeen removes the expired content and records, at most once per minute.
+// m.muSeen must be held.
+func (m *MemoryState) pruneSeen() {
+	now := time.Now()
+	if now.Sub(m.seenPruned) < time.Minute {
+		return
+	}
+	for _, seen := range []map[string]time.Time{m.seenContent, m.seenRecords} {
+		for id, exp := range seen {
+			if !exp.After(now) {
+				delete(seen, id)
+			}
+		}
+	}
+	m.seenPruned = now
 }
 
 func (m *MemoryState) returnState() *StateData {
-	m.muCreated.RLock()
-	m.muRequest.RLock()
-	defer m.muCreated.RUnlock()
-	defer m.muRequest.RUnlock()
+	m.muCheckpoints.RLock()
+	m.muSeen.RLock()
+	defer m.muCheckpoints.RUnlock()
+	defer m.muSeen.RUnlock()
 
-	return &StateData{
-		LastContentCreated: m.lastContentCreated,
-		LastRequestTime:    m.lastRequestTime,
+	b := &StateData{
+		Checkpoints: make(map[schema.ContentType]Checkpoint, len(m.checkpoints)),
+		SeenContent: make(map[string]time.Time, len(m.seenContent)),
+		SeenRecords: make(map[string]time.Time, len(m.seenRecords)),
+	}
+	for ct, c := range m.checkpoints {
+		b.Checkpoints[ct] = c
+	}
+	for id, exp := range m.seenContent {
+		b.SeenContent[id] = exp
+	}
+	for id, exp := range m.seenRecords {
+		b.SeenRecords[id] = exp
 	}
+	return b
 }
 
 func (m *MemoryState) setState(b *StateData) {
-	m.muCreated.Lock()
-	m.muRequest.Lock()
-	defer m.muCreated.Unlock()
-	defer m.muRequest.Unlock()
+	m.muCheckpoints.Lock()
+	m.muSeen.Lock()
+	defer m.muCheckpoints.Unlock()
+	defer m.muSeen.Unlock()
 
-	m.lastContentCreated = b.LastContentCreated
-	m.lastRequestTime = b.LastRequestTime
+	m.checkpoints = b.Checkpoints
+	m.seenContent = b.SeenContent
+	m.seenRecords = b.SeenRecords
+	if m.checkpoints == nil {
+		m.checkpoints = make(map[schema.ContentType]Checkpoint)
+	}
+	if m.seenContent == nil {
+		m.seenContent = make(map[string]time.Time)
+	}
+	if m.seenRecords == nil {
+		m.seenRecords = make(map[string]time.Time)
+	}
+
+	// state written before checkpoints
+	for ct, t := range b.LastContentCreated {
+		c := m.checkpoints[ct]
+		if c.LastContentCreated.Before(t) {
+			c.LastContentCreated = t
+		}
+		m.checkpoints[ct] = c
+	}
+	for ct, t := range b.LastRequestTime {
+		c := m.checkpoints[ct]
+		if c.LastRequestTime.Before(t) {
+			c.LastRequestTime = t
+		}
+		m.checkpoints[ct] = c
+	}
 }
 
 // Read will decode json from a reader and populate its state.
+// The state written by previous versions, without Checkpoints, is supported.
 func (m *MemoryState) Read(r io.Reader) error {
 	decoder := json.NewDecoder(r)
 
@@ -126,6 +313,16 @@ func (m *MemoryState) Write(w io.Writer) error {
 
 // StateData holds the internal state of MemoryState.
 type StateData struct {
-	LastContentCreated map[schema.ContentType]time.Time
-	LastRequestTime    map[schema.ContentType]time.Time
+	// Checkpoints holds the checkpoint of every content type.
+	Checkpoints map[schema.ContentType]Checkpoint `json:",omitempty"`
+	// Deprecated: LastContentCreated is only read from the state written by
+	// previous versions, use Checkpoints.
+	LastContentCreated map[schema.ContentType]time.Time `json:",omitempty"`
+	// Deprecated: LastRequestTime is only read from the state written by
+	// previous versions, use Checkpoints.
+	LastRequestTime map[schema.ContentType]time.Time `json:",omitempty"`
+	// SeenContent holds the expiration of content blobs already fetched, by content ID.
+	SeenContent map[string]time.Time `json:",omitempty"`
+	// SeenRecords holds the expiration of audit records already emitted, by record ID.
+	SeenRecords map[string]time.Time `json:",omitempty"`
 }
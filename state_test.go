@@ -0,0 +1,139 @@
+package office365
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// getCheckpoint returns the checkpoint of a content type, failing the test on error.
+func getCheckpoint(t *testing.T, s State, ct schema.ContentType) Checkpoint {
+	t.Helper()
+	c, err := s.Checkpoint(context.Background(), ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMemoryStateCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	ct := schema.AuditGeneral
+	state := NewMemoryState()
+	now := time.Now()
+
+	testDeep(t, getCheckpoint(t, state, ct), Checkpoint{})
+	if err := setLastRequestTime(ctx, state, ct, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLastContentCreated(ctx, state, ct, now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// checkpoints never move backward
+	if err := setLastRequestTime(ctx, state, ct, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	want := Checkpoint{LastContentCreated: now.Add(-time.Minute), LastRequestTime: now}
+	testDeep(t, getCheckpoint(t, state, ct), want)
+	testDeep(t, getCheckpoint(t, state, schema.AuditExchange), Checkpoint{})
+
+	if err := state.SetRecordSeen(ctx, "a", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetRecordSeen(ctx, "b", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if seen, _ := state.RecordSeen(ctx, "a"); !seen {
+		t.Error("record a must be seen")
+	}
+	if seen, _ := state.RecordSeen(ctx, "b"); seen {
+		t.Error("expired record b must not be seen")
+	}
+
+	var buf bytes.Buffer
+	if err := state.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemoryState()
+	if err := restored.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := getCheckpoint(t, restored, ct)
+	if !got.LastContentCreated.Equal(want.LastContentCreated) || !got.LastRequestTime.Equal(want.LastRequestTime) {
+		t.Errorf("got checkpoint %+v but want %+v", got, want)
+	}
+	if seen, _ := restored.RecordSeen(ctx, "a"); !seen {
+		t.Error("record a must be seen after a restart")
+	}
+}
+
+func TestMemoryStateReadLegacy(t *testing.T) {
+	// state written before checkpoints
+	legacy := `{"LastContentCreated":{"0":"2024-01-02T03:04:05Z","1":"2024-01-02T00:00:00Z"},` +
+		`"LastRequestTime":{"0":"2024-01-02T04:00:00Z"},"SeenContent":{"blob":"2024-01-09T00:00:00Z"}}`
+
+	state := NewMemoryState()
+	if err := state.Read(strings.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	testDeep(t, state.checkpoints, map[schema.ContentType]Checkpoint{
+		schema.ContentType(0): {
+			LastContentCreated: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			LastRequestTime:    time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC),
+		},
+		schema.ContentType(1): {
+			LastContentCreated: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	testDeep(t, state.seenContent, map[string]time.Time{"blob": time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)})
+
+	// the state is written in the current format
+	var buf bytes.Buffer
+	if err := state.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); strings.Contains(s, "LastRequestTime\":{") || !strings.Contains(s, "Checkpoints") {
+		t.Errorf("got state %s in the legacy format", s)
+	}
+}
+
+func TestFileState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	server, client := newTestServer(t)
+
+	ct := schema.AuditGeneral
+	server.StartSubscription("tenant", ct)
+	addRecords(t, server, ct, time.Now().Add(-10*time.Minute), "a")
+
+	state, err := NewFileState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 60}
+	ctx, cancel := context.WithCancel(context.Background())
+	_, wait := runTestWatcher(ctx, t, client, conf, state, handler)
+	receiveRecords(t, handler.ch, 1)
+	cancel()
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is written by Run, without calling Write
+	restored, err := NewFileState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getCheckpoint(t, restored, ct); got.LastContentCreated.IsZero() {
+		t.Errorf("got checkpoint %+v from the state file", got)
+	}
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 0 {
+		t.Errorf("got temporary files %v", matches)
+	}
+}
@@ -18,17 +18,23 @@ type SubscriptionService service
 //
 // List current subscriptions
 // This operation returns a collection of the current subscriptions together with the associated webhooks.
-func (s *SubscriptionService) List(ctx context.Context) (*Response, []Subscription, error) {
+func (s *SubscriptionService) List(ctx context.Context, opts ...CallOption) (*Response, []Subscription, error) {
+	settings := s.client.newCallSettings(opts)
 	params := NewQueryParams()
-	params.AddPubIdentifier(s.client.pubIdentifier)
+	params.AddPubIdentifier(settings.pubIdentifier)
 
 	req, err := s.client.newRequest("GET", "subscriptions/list", params.Values, nil)
 	if err != nil {
 		return nil, nil, err
 	}
+	settings.apply(req)
 
+	ctx, cancel := withCallSettings(ctx, settings)
+	defer cancel()
+	ctx, span := s.client.startOperation(ctx, OperationSubscriptionList, nil)
 	var out []Subscription
 	resp, err := s.client.do(ctx, req, &out)
+	span.end(ctx, resp, err)
 	return resp, out, err
 }
 
@@ -53,16 +59,26 @@ func (s *SubscriptionService) List(ctx context.Context) (*Response, []Subscripti
 // If we do not receive an HTTP 200 OK response, the subscription will not be created.
 // Or, if /start is being called to add a webhook to an existing subscription and a response of HTTP 200 OK
 // is not received, the webhook will not be added and the subscription will remain unchanged.
-func (s *SubscriptionService) Start(ctx context.Context, ct *schema.ContentType, webhook *Webhook) (*Response, *Subscription, error) {
+func (s *SubscriptionService) Start(ctx context.Context, ct *schema.ContentType, webhook *Webhook, opts ...CallOption) (*Response, *Subscription, error) {
+	var body map[string]any
+	if webhook != nil {
+		body = map[string]any{"webhook": webhook}
+	}
+	return s.start(ctx, ct, body, opts)
+}
+
+// start starts a subscription, sending the provided body if not nil.
+func (s *SubscriptionService) start(ctx context.Context, ct *schema.ContentType, body map[string]any, opts []CallOption) (*Response, *Subscription, error) {
+	settings := s.client.newCallSettings(opts)
 	params := NewQueryParams()
-	params.AddPubIdentifier(s.client.pubIdentifier)
+	params.AddPubIdentifier(settings.pubIdentifier)
 	if err := params.AddContentType(ct); err != nil {
 		return nil, nil, err
 	}
 
 	var payload io.Reader
-	if webhook != nil {
-		data, err := json.Marshal(map[string]any{"webhook": webhook})
+	if body != nil {
+		data, err := json.Marshal(body)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -74,12 +90,17 @@ func (s *SubscriptionService) Start(ctx context.Context, ct *schema.ContentType,
 		return nil, nil, err
 	}
 
-	if webhook != nil {
+	if body != nil {
 		req.Header.Set("Content-Type", "application/json; utf-8")
 	}
+	settings.apply(req)
 
+	ctx, cancel := withCallSettings(ctx, settings)
+	defer cancel()
+	ctx, span := s.client.startOperation(ctx, OperationSubscriptionStart, ct)
 	var out *Subscription
 	resp, err := s.client.do(ctx, req, &out)
+	span.end(ctx, resp, err)
 	return resp, out, err
 }
 
@@ -91,9 +112,10 @@ func (s *SubscriptionService) Start(ctx context.Context, ct *schema.ContentType,
 // When a subscription is stopped, you will no longer receive notifications and you will not be able to retrieve available content.
 // If the subscription is later restarted, you will have access to new content from that point forward.
 // You will not be able to retrieve content that was available between the time the subscription was stopped and restarted.
-func (s *SubscriptionService) Stop(ctx context.Context, ct *schema.ContentType) (*Response, error) {
+func (s *SubscriptionService) Stop(ctx context.Context, ct *schema.ContentType, opts ...CallOption) (*Response, error) {
+	settings := s.client.newCallSettings(opts)
 	params := NewQueryParams()
-	params.AddPubIdentifier(s.client.pubIdentifier)
+	params.AddPubIdentifier(settings.pubIdentifier)
 	if err := params.AddContentType(ct); err != nil {
 		return nil, err
 	}
@@ -102,8 +124,13 @@ func (s *SubscriptionService) Stop(ctx context.Context, ct *schema.ContentType)
 	if err != nil {
 		return nil, err
 	}
+	settings.apply(req)
 
+	ctx, cancel := withCallSettings(ctx, settings)
+	defer cancel()
+	ctx, span := s.client.startOperation(ctx, OperationSubscriptionStop, ct)
 	resp, err := s.client.do(ctx, req, nil)
+	span.end(ctx, resp, err)
 	return resp, err
 }
 
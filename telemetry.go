@@ -0,0 +1,147 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer and the meter of the package.
+const instrumentationName = "github.com/orlangure/go-office365"
+
+// Attributes set on spans and metrics.
+const (
+	attrOperation   = attribute.Key("office365.operation")
+	attrContentType = attribute.Key("office365.content_type")
+	attrPageCount   = attribute.Key("office365.page_count")
+	attrBlobCount   = attribute.Key("office365.blob_count")
+	attrRecordCount = attribute.Key("office365.record_count")
+	attrContentID   = attribute.Key("office365.content_id")
+	attrAttempts    = attribute.Key("office365.attempts")
+	attrRequestID   = attribute.Key("office365.request_id")
+	attrDuplicate   = attribute.Key("office365.duplicate")
+	attrStatusCode  = attribute.Key("http.response.status_code")
+)
+
+// Operations of the watcher, reported along with the client ones.
+const (
+	operationWatcherFetchContent = "watcher/fetch_content"
+	operationWatcherFetchAudits  = "watcher/fetch_audits"
+)
+
+// WithTracerProvider makes the client and its watchers create spans using the provided provider.
+// The global provider is used otherwise, see otel.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(c *Client) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider makes the client and its watchers record metrics using the provided provider.
+// The global provider is used otherwise, see otel.SetMeterProvider.
+func WithMeterProvider(mp metric.MeterProvider) ClientOption {
+	return func(c *Client) {
+		c.meterProvider = mp
+	}
+}
+
+// telemetry holds the instruments used by a client and its watchers.
+type telemetry struct {
+	tracer trace.Tracer
+
+	// duration is the latency of operations, in seconds.
+	duration metric.Float64Histogram
+	// errors counts failed operations.
+	errors metric.Int64Counter
+	// blobs counts content blobs listed by watchers.
+	blobs metric.Int64Counter
+	// records counts audit records emitted by watchers.
+	records metric.Int64Counter
+	// duplicates counts content blobs and records skipped by watchers as duplicates.
+	duplicates metric.Int64Counter
+}
+
+// newTelemetry creates the instruments from the provided providers,
+// falling back to the global ones if nil.
+// Instruments that cannot be created are replaced by no-op ones by the metric API.
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	t.duration, _ = meter.Float64Histogram("office365.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of API operations and watcher fetch cycles."))
+	t.errors, _ = meter.Int64Counter("office365.operation.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of failed API operations and watcher fetch cycles."))
+	t.blobs, _ = meter.Int64Counter("office365.watcher.blobs",
+		metric.WithUnit("{blob}"),
+		metric.WithDescription("Number of content blobs listed by watchers."))
+	t.records, _ = meter.Int64Counter("office365.watcher.records",
+		metric.WithUnit("{record}"),
+		metric.WithDescription("Number of audit records emitted by watchers."))
+	t.duplicates, _ = meter.Int64Counter("office365.watcher.duplicates",
+		metric.WithUnit("{duplicate}"),
+		metric.WithDescription("Number of content blobs and audit records skipped by watchers as duplicates."))
+	return t
+}
+
+// span is an operation being traced and measured.
+type span struct {
+	t     *telemetry
+	span  trace.Span
+	start time.Time
+	attrs []attribute.KeyValue
+}
+
+// start starts a span for the provided operation.
+func (t *telemetry) start(ctx context.Context, op string, ct *schema.ContentType) (context.Context, *span) {
+	attrs := []attribute.KeyValue{attrOperation.String(op)}
+	if ct != nil {
+		attrs = append(attrs, attrContentType.String(ct.String()))
+	}
+	ctx, s := t.tracer.Start(ctx, "office365 "+op, trace.WithAttributes(attrs...))
+	return ctx, &span{t: t, span: s, start: time.Now(), attrs: attrs}
+}
+
+// end ends the span, recording the response and the error, if any, along with
+// the provided attributes.
+// Context cancellations are not counted as errors.
+func (s *span) end(ctx context.Context, resp *Response, err error, attrs ...attribute.KeyValue) {
+	if resp != nil && resp.Response != nil {
+		attrs = append(attrs, attrStatusCode.Int(resp.Response.StatusCode), attrAttempts.Int(resp.Attempts))
+		if resp.RequestID != "" {
+			attrs = append(attrs, attrRequestID.String(resp.RequestID))
+		}
+	}
+	s.span.SetAttributes(attrs...)
+
+	metricAttrs := metric.WithAttributes(s.attrs...)
+	s.t.duration.Record(ctx, time.Since(s.start).Seconds(), metricAttrs)
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, errWatcherDone) {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+		s.t.errors.Add(ctx, 1, metricAttrs)
+	}
+	s.span.End()
+}
+
+// startOperation starts a span for an API operation, and sets up the
+// returned context for reporting the operation to interceptors.
+func (c *Client) startOperation(ctx context.Context, op Operation, ct *schema.ContentType) (context.Context, *span) {
+	ctx = withOperation(ctx, op, ct)
+	return c.telemetry.start(ctx, string(op), requestInfoFromContext(ctx).ContentType)
+}
@@ -0,0 +1,174 @@
+package office365
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func stubTelemetryClient() (*Client, *http.ServeMux, *tracetest.InMemoryExporter, *sdkmetric.ManualReader, func()) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	client := NewClient(nil, "test-tenandID", "",
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+	url, _ := url.Parse(server.URL + "/")
+	client.BaseURL = url
+
+	return client, mux, exporter, reader, server.Close
+}
+
+func spanAttributes(s tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTelemetrySpans(t *testing.T) {
+	client, mux, exporter, _, teardown := stubTelemetryClient()
+	defer teardown()
+
+	contentURL := client.getURL("subscriptions/content", nil)
+	mux.HandleFunc(contentURL.Path, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextpage") == "" {
+			w.Header().Set("NextPageUri", contentURL.String()+"?nextpage=2")
+		}
+		fmt.Fprint(w, `[{"contentId": "abc"}]`)
+	})
+	mux.HandleFunc(client.getURL("audit/abc", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"Id": "1"}, {"Id": "2"}]`)
+	})
+	mux.HandleFunc(client.getURL("subscriptions/stop", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	if _, _, err := client.Content.List(context.Background(), &ct, now.Add(-time.Hour), now); err != nil {
+		t.Fatalf("error occurred running Content.List: %v", err)
+	}
+	if _, _, err := client.Audit.List(context.Background(), "abc", false); err != nil {
+		t.Fatalf("error occurred running Audit.List: %v", err)
+	}
+	if _, err := client.Subscription.Stop(context.Background(), &ct); err == nil {
+		t.Fatal("expected an error running Subscriptions.Stop")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans but want 3", len(spans))
+	}
+
+	cases := []struct {
+		Name   string
+		Attrs  map[attribute.Key]attribute.Value
+		Status codes.Code
+	}{
+		{
+			Name: "office365 content/list",
+			Attrs: map[attribute.Key]attribute.Value{
+				attrOperation:   attribute.StringValue("content/list"),
+				attrContentType: attribute.StringValue("Audit.General"),
+				attrPageCount:   attribute.IntValue(2),
+				attrStatusCode:  attribute.IntValue(http.StatusOK),
+			},
+		},
+		{
+			Name: "office365 audit/fetch",
+			Attrs: map[attribute.Key]attribute.Value{
+				attrOperation:   attribute.StringValue("audit/fetch"),
+				attrRecordCount: attribute.IntValue(2),
+				attrStatusCode:  attribute.IntValue(http.StatusOK),
+			},
+		},
+		{
+			Name: "office365 subscriptions/stop",
+			Attrs: map[attribute.Key]attribute.Value{
+				attrOperation:   attribute.StringValue("subscriptions/stop"),
+				attrContentType: attribute.StringValue("Audit.General"),
+				attrStatusCode:  attribute.IntValue(http.StatusBadRequest),
+			},
+			Status: codes.Error,
+		},
+	}
+	for i, tc := range cases {
+		s := spans[i]
+		if s.Name != tc.Name {
+			t.Errorf("span %d: got name %q but want %q", i, s.Name, tc.Name)
+		}
+		attrs := spanAttributes(s)
+		for k, want := range tc.Attrs {
+			if got, ok := attrs[k]; !ok || got != want {
+				t.Errorf("span %s: got %s=%v but want %v", s.Name, k, got.Emit(), want.Emit())
+			}
+		}
+		if s.Status.Code != tc.Status {
+			t.Errorf("span %s: got status %v but want %v", s.Name, s.Status.Code, tc.Status)
+		}
+	}
+}
+
+func TestTelemetryMetrics(t *testing.T) {
+	client, mux, _, reader, teardown := stubTelemetryClient()
+	defer teardown()
+
+	mux.HandleFunc(client.getURL("subscriptions/list", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc(client.getURL("audit/abc", nil).Path, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	if _, _, err := client.Subscription.List(context.Background()); err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	if _, _, err := client.Audit.List(context.Background(), "abc", false); err == nil {
+		t.Fatal("expected an error running Audit.List")
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	durations := make(map[string]uint64)
+	errorCounts := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					op, _ := dp.Attributes.Value(attrOperation)
+					durations[op.AsString()] += dp.Count
+				}
+			case metricdata.Sum[int64]:
+				if m.Name != "office365.operation.errors" {
+					continue
+				}
+				for _, dp := range data.DataPoints {
+					op, _ := dp.Attributes.Value(attrOperation)
+					errorCounts[op.AsString()] += dp.Value
+				}
+			}
+		}
+	}
+	testDeep(t, durations, map[string]uint64{"subscriptions/list": 1, "audit/fetch": 1})
+	testDeep(t, errorCounts, map[string]int64{"audit/fetch": 1})
+}
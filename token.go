@@ -0,0 +1,179 @@
+package office365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// defaultRefreshWindow is how long before expiry a cached token is renewed.
+	defaultRefreshWindow = 5 * time.Minute
+
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// tokenResponse is the json object returned by Azure AD token endpoints.
+// The legacy endpoints return expires_in as a string, newer ones as a number.
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// TokenError is returned when a token endpoint rejects a token request.
+type TokenError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("token request failed: %d %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// contextHTTPClient returns the http.Client stored in ctx under oauth2.HTTPClient,
+// or http.DefaultClient.
+func contextHTTPClient(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		return c
+	}
+	return http.DefaultClient
+}
+
+// retrieveToken posts the form to the token endpoint and decodes the token returned.
+func retrieveToken(ctx context.Context, tokenURL string, form url.Values) (*oauth2.Token, error) {
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(ctx, req)
+}
+
+// doTokenRequest sends a token request and decodes the token returned.
+func doTokenRequest(ctx context.Context, req *http.Request) (*oauth2.Token, error) {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := contextHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var out tokenResponse
+	decErr := json.Unmarshal(body, &out)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || out.Error != "" {
+		return nil, &TokenError{StatusCode: resp.StatusCode, Code: out.Error, Description: out.ErrorDescription}
+	}
+	if decErr != nil {
+		return nil, fmt.Errorf("decoding token response: %w", decErr)
+	}
+	if out.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+
+	token := &oauth2.Token{AccessToken: out.AccessToken, TokenType: out.TokenType}
+	if out.ExpiresIn != "" {
+		secs, err := strconv.ParseInt(string(out.ExpiresIn), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing expires_in: %w", err)
+		}
+		token.Expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return token, nil
+}
+
+// assertionTokenSource requests tokens using the client credentials grant,
+// authenticating with a JWT client assertion instead of a secret.
+type assertionTokenSource struct {
+	ctx       context.Context
+	clientID  string
+	tokenURL  string
+	params    url.Values
+	assertion func() (string, error)
+}
+
+// certificateAssertion returns an assertion func signing a new JWT with cert on each call.
+func certificateAssertion(cert *ClientCertificate, clientID, tokenURL string) func() (string, error) {
+	return func() (string, error) {
+		return cert.Assertion(clientID, tokenURL, time.Now())
+	}
+}
+
+// Token implements the oauth2.TokenSource interface.
+func (s *assertionTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := s.assertion()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {s.clientID},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	for k, v := range s.params {
+		form[k] = v
+	}
+	return retrieveToken(s.ctx, s.tokenURL, form)
+}
+
+// tokenClient returns an http.Client authorizing its requests with the tokens of src,
+// sent through the http.Client stored in ctx under oauth2.HTTPClient.
+// Unlike oauth2.NewClient, src is not wrapped in a ReuseTokenSource,
+// which would only ask src for a new token once the cached one is about to expire
+// and so hide the refresh window of a cachingTokenSource.
+func tokenClient(ctx context.Context, src oauth2.TokenSource) *http.Client {
+	return &http.Client{Transport: &oauth2.Transport{Source: src, Base: contextHTTPClient(ctx).Transport}}
+}
+
+// cachingTokenSource caches the token returned by the underlying source
+// and renews it proactively, refreshWindow before it expires.
+// If renewing fails while the cached token is still valid, the cached token is returned.
+type cachingTokenSource struct {
+	mu            sync.Mutex
+	src           oauth2.TokenSource
+	token         *oauth2.Token
+	refreshWindow time.Duration
+}
+
+func newCachingTokenSource(src oauth2.TokenSource, refreshWindow time.Duration) *cachingTokenSource {
+	return &cachingTokenSource{src: src, refreshWindow: refreshWindow}
+}
+
+// Token implements the oauth2.TokenSource interface.
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != nil && (s.token.Expiry.IsZero() || now.Add(s.refreshWindow).Before(s.token.Expiry)) {
+		return s.token, nil
+	}
+
+	token, err := s.src.Token()
+	if err != nil {
+		if s.token != nil && now.Before(s.token.Expiry) {
+			return s.token, nil
+		}
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
@@ -0,0 +1,188 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// defaultIMDSEndpoint is the Azure Instance Metadata Service token endpoint.
+	defaultIMDSEndpoint   = "http://169.254.169.254/metadata/identity/oauth2/token"
+	defaultIMDSAPIVersion = "2018-02-01"
+
+	// federatedTokenFileEnv is the variable set by the Azure workload identity webhook.
+	federatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+)
+
+// ErrFederatedTokenFileRequired is returned when no federated token file is known.
+var ErrFederatedTokenFileRequired = errors.New("federated token file is required")
+
+// TokenURLV2 returns the Microsoft identity platform (v2.0) token endpoint
+// of the environment for the provided tenant.
+func (e Environment) TokenURLV2(tenant string) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(e.AuthorityURL, "/"), tenant)
+}
+
+// Scope returns the v2.0 scope granting access to the Management Activity API.
+func (e Environment) Scope() string {
+	return strings.TrimSuffix(e.Resource, "/") + "/.default"
+}
+
+// tenantOf returns the tenant the credentials authenticate against.
+func (c *Credentials) tenantOf() string {
+	if c.TenantDomain != "" {
+		return c.TenantDomain
+	}
+	return c.TenantID
+}
+
+// NewV2TokenSource returns a TokenSource using the client credentials grant
+// against the Microsoft identity platform (v2.0) endpoint.
+// The credentials Certificate is used if set, the ClientSecret otherwise.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/azure/active-directory/develop/v2-oauth2-client-creds-grant-flow
+func NewV2TokenSource(ctx context.Context, c *Credentials) oauth2.TokenSource {
+	env := environmentOrDefault(c.Environment)
+	tokenURL := env.TokenURLV2(c.tenantOf())
+	if c.Certificate != nil {
+		src := &assertionTokenSource{
+			ctx:       ctx,
+			clientID:  c.ClientID,
+			tokenURL:  tokenURL,
+			params:    url.Values{"scope": []string{env.Scope()}},
+			assertion: certificateAssertion(c.Certificate, c.ClientID, tokenURL),
+		}
+		return newCachingTokenSource(src, defaultRefreshWindow)
+	}
+	src := &secretTokenSource{
+		ctx:          ctx,
+		clientID:     c.ClientID,
+		clientSecret: c.ClientSecret,
+		tokenURL:     tokenURL,
+		params:       url.Values{"scope": []string{env.Scope()}},
+	}
+	return newCachingTokenSource(src, defaultRefreshWindow)
+}
+
+// secretTokenSource requests tokens using the client credentials grant with a client secret.
+// Unlike the source of clientcredentials.Config, it does not reuse its tokens,
+// leaving it to the cachingTokenSource wrapping it.
+type secretTokenSource struct {
+	ctx          context.Context
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	params       url.Values
+}
+
+// Token implements the oauth2.TokenSource interface.
+func (s *secretTokenSource) Token() (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+	}
+	for k, v := range s.params {
+		form[k] = v
+	}
+	return retrieveToken(s.ctx, s.tokenURL, form)
+}
+
+// NewWorkloadIdentityTokenSource returns a TokenSource exchanging a federated token,
+// such as a Kubernetes service account token, for an access token.
+// The file is read again on every token request since it is rotated by its issuer.
+// If tokenFile is empty, the AZURE_FEDERATED_TOKEN_FILE environment variable is used.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/azure/active-directory/develop/workload-identity-federation
+func NewWorkloadIdentityTokenSource(ctx context.Context, c *Credentials, tokenFile string) (oauth2.TokenSource, error) {
+	if tokenFile == "" {
+		tokenFile = os.Getenv(federatedTokenFileEnv)
+	}
+	if tokenFile == "" {
+		return nil, ErrFederatedTokenFileRequired
+	}
+	env := environmentOrDefault(c.Environment)
+	src := &assertionTokenSource{
+		ctx:      ctx,
+		clientID: c.ClientID,
+		tokenURL: env.TokenURLV2(c.tenantOf()),
+		params:   url.Values{"scope": []string{env.Scope()}},
+		assertion: func() (string, error) {
+			data, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return "", fmt.Errorf("reading federated token: %w", err)
+			}
+			return strings.TrimSpace(string(data)), nil
+		},
+	}
+	return newCachingTokenSource(src, defaultRefreshWindow), nil
+}
+
+// ManagedIdentityConfig configures a managed identity TokenSource.
+type ManagedIdentityConfig struct {
+	// Endpoint is the token endpoint of the metadata service.
+	// The Azure Instance Metadata Service is used if empty.
+	Endpoint string
+	// ClientID selects a user-assigned identity.
+	// The system-assigned identity is used if empty.
+	ClientID string
+	// Environment is the Microsoft cloud the tokens are requested for.
+	// EnvironmentCommercial is used if nil.
+	Environment *Environment
+}
+
+// managedIdentityTokenSource requests tokens from the metadata service.
+type managedIdentityTokenSource struct {
+	ctx      context.Context
+	endpoint string
+	params   url.Values
+}
+
+// Token implements the oauth2.TokenSource interface.
+func (s *managedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// the parameters set in the endpoint, e.g. api-version, take precedence
+	query := u.Query()
+	for k, v := range s.params {
+		if _, ok := query[k]; !ok {
+			query[k] = v
+		}
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	return doTokenRequest(s.ctx, req)
+}
+
+// NewManagedIdentityTokenSource returns a TokenSource getting tokens for
+// the managed identity of the host from the metadata service.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
+func NewManagedIdentityTokenSource(ctx context.Context, conf ManagedIdentityConfig) oauth2.TokenSource {
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	params := url.Values{
+		"api-version": []string{defaultIMDSAPIVersion},
+		"resource":    []string{environmentOrDefault(conf.Environment).Resource},
+	}
+	if conf.ClientID != "" {
+		params.Set("client_id", conf.ClientID)
+	}
+	src := &managedIdentityTokenSource{ctx: ctx, endpoint: endpoint, params: params}
+	return newCachingTokenSource(src, defaultRefreshWindow)
+}
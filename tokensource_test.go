@@ -0,0 +1,197 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenServer returns a fake token endpoint recording the requests it receives.
+func tokenServer(t *testing.T, expiresIn string) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("error parsing token request: %s", err)
+		}
+		requests = append(requests, r)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %s}`, len(requests), expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestV2TokenSource(t *testing.T) {
+	server, requests := tokenServer(t, "3600")
+	env := Environment{Name: "test", AuthorityURL: server.URL, Resource: "https://manage.office365.us"}
+	creds := &Credentials{
+		ClientID:     "test-clientID",
+		ClientSecret: "test-secret",
+		TenantID:     "test-tenantID",
+		Environment:  &env,
+	}
+
+	token, err := NewV2TokenSource(context.Background(), creds).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "token-1" {
+		t.Errorf("got token %v but want token-1", token.AccessToken)
+	}
+	r := (*requests)[0]
+	if r.URL.Path != "/test-tenantID/oauth2/v2.0/token" {
+		t.Errorf("got path %v but want /test-tenantID/oauth2/v2.0/token", r.URL.Path)
+	}
+	if got := r.PostForm.Get("scope"); got != "https://manage.office365.us/.default" {
+		t.Errorf("got scope %v but want https://manage.office365.us/.default", got)
+	}
+	if r.PostForm.Get("resource") != "" {
+		t.Errorf("resource must not be sent to the v2.0 endpoint")
+	}
+	if got := r.PostForm.Get("client_secret"); got != "test-secret" {
+		t.Errorf("got client_secret %v but want test-secret", got)
+	}
+}
+
+func TestWorkloadIdentityTokenSource(t *testing.T) {
+	server, requests := tokenServer(t, "60")
+	env := Environment{Name: "test", AuthorityURL: server.URL, Resource: EnvironmentCommercial.Resource}
+	creds := &Credentials{ClientID: "test-clientID", TenantID: "test-tenantID", Environment: &env}
+
+	if _, err := NewWorkloadIdentityTokenSource(context.Background(), creds, ""); !errors.Is(err, ErrFederatedTokenFileRequired) {
+		t.Errorf("got error %v but want %v", err, ErrFederatedTokenFileRequired)
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(federatedTokenFileEnv, tokenFile)
+	ts, err := NewWorkloadIdentityTokenSource(context.Background(), creds, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token expires within the refresh window, the rotated file is read again
+	if err := os.WriteFile(tokenFile, []byte("federated-2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	for idx, want := range []string{"federated-1", "federated-2"} {
+		r := (*requests)[idx]
+		if got := r.PostForm.Get("client_assertion"); got != want {
+			t.Errorf("got client_assertion %v but want %v", got, want)
+		}
+		if got := r.PostForm.Get("client_assertion_type"); got != clientAssertionType {
+			t.Errorf("got client_assertion_type %v but want %v", got, clientAssertionType)
+		}
+	}
+}
+
+func TestManagedIdentityTokenSource(t *testing.T) {
+	server, requests := tokenServer(t, `"3600"`)
+	ts := NewManagedIdentityTokenSource(context.Background(), ManagedIdentityConfig{
+		Endpoint:    server.URL + "/metadata/identity/oauth2/token",
+		ClientID:    "test-identity",
+		Environment: &EnvironmentGCCHigh,
+	})
+
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Expiry.IsZero() {
+		t.Errorf("token expiry was not parsed")
+	}
+	r := (*requests)[0]
+	if r.Method != "GET" || r.Header.Get("Metadata") != "true" {
+		t.Errorf("got %s request with Metadata header %q", r.Method, r.Header.Get("Metadata"))
+	}
+	query := r.URL.Query()
+	if query.Get("resource") != EnvironmentGCCHigh.Resource || query.Get("client_id") != "test-identity" {
+		t.Errorf("unexpected query: %v", query)
+	}
+	if query.Get("api-version") != defaultIMDSAPIVersion {
+		t.Errorf("got api-version %q but want %q", query.Get("api-version"), defaultIMDSAPIVersion)
+	}
+
+	// the endpoint query is kept
+	server, requests = tokenServer(t, `"3600"`)
+	ts = NewManagedIdentityTokenSource(context.Background(), ManagedIdentityConfig{
+		Endpoint: server.URL + "/msi/token?api-version=2019-08-01&extra=1",
+	})
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	query = (*requests)[0].URL.Query()
+	if query.Get("api-version") != "2019-08-01" || query.Get("extra") != "1" || query.Get("resource") == "" {
+		t.Errorf("unexpected query: %v", query)
+	}
+}
+
+func TestClientTokenSourceRefresh(t *testing.T) {
+	// tokens expiring within the refresh window are renewed before every request
+	authority, requests := tokenServer(t, "120")
+	var tokens []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		fmt.Fprint(w, `[]`)
+	}))
+	defer api.Close()
+
+	env := Environment{Name: "test", ManagementURL: api.URL, AuthorityURL: authority.URL, Resource: EnvironmentCommercial.Resource}
+	creds := &Credentials{
+		ClientID:     "test-clientID",
+		ClientSecret: "test-secret",
+		TenantID:     "test-tenantID",
+		Environment:  &env,
+	}
+	creds.TokenSource = NewV2TokenSource(context.Background(), creds)
+	client := NewClientAuthenticated(creds, "")
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Subscription.List(context.Background()); err != nil {
+			t.Fatalf("error occurred running Subscriptions.List: %v", err)
+		}
+	}
+	if len(*requests) != 3 {
+		t.Errorf("got %d token requests but want 3", len(*requests))
+	}
+	testDeep(t, tokens, []string{"Bearer token-1", "Bearer token-2", "Bearer token-3"})
+}
+
+func TestClientTokenSource(t *testing.T) {
+	client, mux, teardown := stubClient()
+	defer teardown()
+
+	creds := &Credentials{
+		TenantID:    "test-tenandID",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "static-token"}),
+	}
+	authenticated := NewClientAuthenticated(creds, "")
+	authenticated.BaseURL = client.BaseURL
+
+	url := client.getURL("subscriptions/list", nil)
+	mux.HandleFunc(url.Path, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer static-token" {
+			t.Errorf("got authorization %v but want Bearer static-token", got)
+		}
+		fmt.Fprint(w, `[]`)
+	})
+	if _, _, err := authenticated.Subscription.List(context.Background()); err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+}
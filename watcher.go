@@ -4,13 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/orlangure/go-office365/schema"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
 )
 
+// errWatcherDone is used internally for stopping a stream when the watcher exits.
+var errWatcherDone = errors.New("watcher is done")
+
+var defaultAckTimeoutSeconds = 5 * 60
+
 // Watcher is an interface used by Watch for generating a stream of records.
 type Watcher interface {
 	Run(context.Context) chan ResourceAudits
@@ -20,9 +27,19 @@ type Watcher interface {
 // It fetches current subscriptions, then queries content available for a given interval
 // and proceed to query audit records.
 type SubscriptionWatcher struct {
-	client *Client
-	config SubscriptionWatcherConfig
-	logger *logrus.Logger
+	client   *Client
+	config   SubscriptionWatcherConfig
+	settings map[schema.ContentType]contentTypeSettings
+	logger   *slog.Logger
+
+	// dedup is State as a DedupState, nil unless required.
+	dedup            DedupState
+	duplicateContent atomic.Int64
+	duplicateRecords atomic.Int64
+	// inflightRecords holds the IDs of the records sent but not acknowledged yet,
+	// along with the ID of their content blob.
+	muRecords       sync.Mutex
+	inflightRecords map[string]string
 
 	State
 	Handler ResourceHandler
@@ -33,31 +50,168 @@ type SubscriptionWatcherConfig struct {
 	LookBehindMinutes     int
 	TickerIntervalSeconds int
 	AddExtendedSchemas    bool
+	// Concurrency is the number of content blobs of a content type fetched at once.
+	// It defaults to 1. Records are still emitted in the order content is listed,
+	// so the records of a blob are buffered while the blobs listed before it are in flight.
+	Concurrency int
+	// ContentTypes are the content types watched, along with the settings overriding
+	// the ones above. Every content type is watched if empty.
+	ContentTypes map[schema.ContentType]ContentTypeConfig
+	// AtLeastOnce makes State advance only once the handler acknowledged the records,
+	// see ResourceAudits.Ack, so that records not committed are emitted again after
+	// a restart. Otherwise State advances once records are received by the handler.
+	AtLeastOnce bool
+	// AckTimeoutSeconds is how long the handler has to acknowledge the records of a
+	// content blob with AtLeastOnce, before the blob is emitted again. It defaults to
+	// 5 minutes, and should be less than the LookBehindMinutes of any content type
+	// for the blob to be listed again.
+	AckTimeoutSeconds int
+	// Deduplicate skips the content blobs and the audit records already emitted,
+	// remembering their IDs in State, which must implement DedupState, for DedupTTLMinutes. Content is then no longer
+	// skipped based on its creation time, so blobs listed late are still fetched.
+	Deduplicate bool
+	// DedupTTLMinutes is how long IDs are remembered. It defaults to 24 hours,
+	// and must not be less than the LookBehindMinutes of any content type.
+	DedupTTLMinutes int
 }
 
-// NewSubscriptionWatcher returns a new watcher that uses the provided client
-// for querying the API.
-func NewSubscriptionWatcher(client *Client, conf SubscriptionWatcherConfig, s State, h ResourceHandler, l *logrus.Logger) (*SubscriptionWatcher, error) {
-	lookBehindDur := time.Duration(conf.LookBehindMinutes) * time.Minute
+// ContentTypeConfig overrides the settings of a SubscriptionWatcherConfig for a content type.
+// Zero values keep the watcher settings.
+type ContentTypeConfig struct {
+	LookBehindMinutes     int
+	TickerIntervalSeconds int
+	AddExtendedSchemas    *bool
+	Concurrency           int
+}
+
+// contentTypeSettings are the settings of a content type, overrides applied.
+type contentTypeSettings struct {
+	lookBehind         time.Duration
+	tickerInterval     time.Duration
+	addExtendedSchemas bool
+	concurrency        int
+}
+
+// contentTypeSettings returns the settings of the watched content types.
+func (conf SubscriptionWatcherConfig) contentTypeSettings() (map[schema.ContentType]contentTypeSettings, error) {
+	base := ContentTypeConfig{
+		LookBehindMinutes:     conf.LookBehindMinutes,
+		TickerIntervalSeconds: conf.TickerIntervalSeconds,
+		AddExtendedSchemas:    Bool(conf.AddExtendedSchemas),
+		Concurrency:           conf.Concurrency,
+	}
+	if len(conf.ContentTypes) == 0 {
+		settings := make(map[schema.ContentType]contentTypeSettings)
+		for _, ct := range schema.GetContentTypes() {
+			s, err := base.settings()
+			if err != nil {
+				return nil, err
+			}
+			settings[ct] = s
+		}
+		return settings, nil
+	}
+
+	settings := make(map[schema.ContentType]contentTypeSettings, len(conf.ContentTypes))
+	for ct, override := range conf.ContentTypes {
+		if !schema.ContentTypeValid(ct.String()) {
+			return nil, fmt.Errorf("content type %d is not valid", ct)
+		}
+		c := base
+		if override.LookBehindMinutes != 0 {
+			c.LookBehindMinutes = override.LookBehindMinutes
+		}
+		if override.TickerIntervalSeconds != 0 {
+			c.TickerIntervalSeconds = override.TickerIntervalSeconds
+		}
+		if override.AddExtendedSchemas != nil {
+			c.AddExtendedSchemas = override.AddExtendedSchemas
+		}
+		if override.Concurrency != 0 {
+			c.Concurrency = override.Concurrency
+		}
+		s, err := c.settings()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ct.String(), err)
+		}
+		settings[ct] = s
+	}
+	return settings, nil
+}
+
+// settings validates the configuration and returns the resulting settings.
+func (c ContentTypeConfig) settings() (contentTypeSettings, error) {
+	lookBehindDur := time.Duration(c.LookBehindMinutes) * time.Minute
 	if lookBehindDur <= 0 {
-		return nil, fmt.Errorf("lookBehindMinutes must be greater than 0")
+		return contentTypeSettings{}, fmt.Errorf("lookBehindMinutes must be greater than 0")
 	}
 	if lookBehindDur > 24*time.Hour {
-		return nil, fmt.Errorf("lookBehindMinutes must be less than or equal to 24 hours")
+		return contentTypeSettings{}, fmt.Errorf("lookBehindMinutes must be less than or equal to 24 hours")
 	}
 
-	tickerIntervalDur := time.Duration(conf.TickerIntervalSeconds) * time.Second
+	tickerIntervalDur := time.Duration(c.TickerIntervalSeconds) * time.Second
 	if tickerIntervalDur <= 0 {
-		return nil, fmt.Errorf("tickerIntervalSeconds must be greater than 0")
+		return contentTypeSettings{}, fmt.Errorf("tickerIntervalSeconds must be greater than 0")
 	}
 	if tickerIntervalDur > time.Hour {
-		return nil, fmt.Errorf("tickerIntervalSeconds must be less than or equal to 1 hour")
+		return contentTypeSettings{}, fmt.Errorf("tickerIntervalSeconds must be less than or equal to 1 hour")
+	}
+
+	concurrency := c.Concurrency
+	if concurrency < 0 {
+		return contentTypeSettings{}, fmt.Errorf("concurrency must not be negative")
+	}
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	return contentTypeSettings{
+		lookBehind:         lookBehindDur,
+		tickerInterval:     tickerIntervalDur,
+		addExtendedSchemas: c.AddExtendedSchemas != nil && *c.AddExtendedSchemas,
+		concurrency:        concurrency,
+	}, nil
+}
+
+// NewSubscriptionWatcher returns a new watcher that uses the provided client
+// for querying the API.
+// The default slog logger is used if l is nil.
+func NewSubscriptionWatcher(client *Client, conf SubscriptionWatcherConfig, s State, h ResourceHandler, l *slog.Logger) (*SubscriptionWatcher, error) {
+	settings, err := conf.contentTypeSettings()
+	if err != nil {
+		return nil, err
+	}
+	if conf.AtLeastOnce {
+		if conf.AckTimeoutSeconds < 0 {
+			return nil, fmt.Errorf("ackTimeoutSeconds must not be negative")
+		}
+		if conf.AckTimeoutSeconds == 0 {
+			conf.AckTimeoutSeconds = defaultAckTimeoutSeconds
+		}
+	}
+	var dedup DedupState
+	if conf.Deduplicate {
+		var ok bool
+		if dedup, ok = s.(DedupState); !ok {
+			return nil, fmt.Errorf("deduplicate requires a State implementing DedupState")
+		}
+		if conf.DedupTTLMinutes == 0 {
+			conf.DedupTTLMinutes = defaultDedupTTLMinutes
+		}
+		for ct, s := range settings {
+			if time.Duration(conf.DedupTTLMinutes)*time.Minute < s.lookBehind {
+				return nil, fmt.Errorf("%s: dedupTTLMinutes must be greater than or equal to lookBehindMinutes", ct.String())
+			}
+		}
 	}
 
 	watcher := &SubscriptionWatcher{
-		client: client,
-		config: conf,
-		logger: l,
+		client:   client,
+		config:   conf,
+		settings: settings,
+		logger:   loggerOrDefault(l),
+		dedup:    dedup,
+
+		inflightRecords: make(map[string]string),
 
 		State:   s,
 		Handler: h,
@@ -74,19 +228,25 @@ func (s *SubscriptionWatcher) Run(ctx context.Context) error {
 	// setup worker pool
 	// workers receive jobs and send results to output channel
 	workers := make(map[schema.ContentType]chan ResourceSubscription)
-	contentTypes := schema.GetContentTypes()
 
-	wg.Add(len(contentTypes))
-	for _, ct := range contentTypes {
-		s.logger.WithField("content-type", ct.String()).Info("starting worker")
+	wg.Add(len(s.settings))
+	for ct := range s.settings {
+		ct := ct
+		s.logger.Info("starting worker", "content-type", ct.String())
 		ch := make(chan ResourceSubscription, 1)
 		workers[ct] = ch
+		concurrency := s.settings[ct].concurrency
+		cp := newCheckpoint(ctx, ct, s.State, s.logger)
+		if s.config.Deduplicate {
+			cp.onDone = s.setContentDone
+			cp.onRelease = s.releaseContentRecords
+		}
 
 		go func() {
 			defer wg.Done()
 			for res := range ch {
-				contentCh := s.fetchContent(ctx, done, res)
-				auditCh := s.fetchAudits(ctx, done, contentCh)
+				contentCh := s.fetchContent(ctx, done, res, cp)
+				auditCh := s.fetchAudits(ctx, done, contentCh, concurrency, cp)
 
 				for a := range auditCh {
 					out <- a
@@ -105,27 +265,31 @@ func (s *SubscriptionWatcher) Run(ctx context.Context) error {
 	// and create jobs for workers.
 	// this goroutine is responsible for closing worker channels
 	go func() {
-		tickerDur := time.Duration(s.config.TickerIntervalSeconds) * time.Second
-		ticker := time.NewTicker(tickerDur)
+		schedule := s.newPollSchedule()
+		ticker := time.NewTicker(schedule.tick)
 		defer ticker.Stop()
 
-		s.logger.Infoln("start main")
-		s.logger.Infof("using config: %+v", s.config)
+		s.logger.Info("start main")
+		s.logger.Info("using config", "config", s.config)
 
 		fetch := func(t time.Time) {
 			subCh := s.fetchSubscriptions(ctx, done, t)
 			for sub := range subCh {
-				ctLogger := s.logger.WithField("content-type", sub.ContentType.String())
+				ctLogger := s.logger.With("content-type", sub.ContentType.String())
 				workerCh, ok := workers[*sub.ContentType]
 				if !ok {
-					ctLogger.Error("no worker registered for content-type")
+					ctLogger.Debug("content-type not watched, skipping")
+					continue
+				}
+				if !schedule.due(*sub.ContentType, t) {
 					continue
 				}
 				select {
 				default:
 					ctLogger.Warn("worker is busy, skipping")
 				case workerCh <- sub:
-					ctLogger.Debugln("sent work")
+					schedule.polled(*sub.ContentType, t)
+					ctLogger.Debug("sent work")
 				}
 			}
 		}
@@ -136,15 +300,16 @@ func (s *SubscriptionWatcher) Run(ctx context.Context) error {
 			select {
 			case <-done:
 				for ct, workerCh := range workers {
-					s.logger.WithField("content-type", ct.String()).Info("closing worker")
+					s.logger.Info("closing worker", "content-type", ct.String())
 					close(workerCh)
 				}
 				break Loop
 			case t := <-ticker.C:
+				s.flush(ctx)
 				fetch(t)
 			}
 		}
-		s.logger.Infoln("end main")
+		s.logger.Info("end main")
 	}()
 
 	// this goroutine is responsible for notifying
@@ -154,7 +319,17 @@ func (s *SubscriptionWatcher) Run(ctx context.Context) error {
 		close(done)
 	}()
 
-	return s.Handler.Handle(out)
+	err := s.Handler.Handle(out)
+	return errors.Join(err, s.flush(context.WithoutCancel(ctx)))
+}
+
+// flush flushes State, logging the error if any.
+func (s *SubscriptionWatcher) flush(ctx context.Context) error {
+	err := s.Flush(ctx)
+	if err != nil {
+		s.logger.Error("could not flush state", "error", err)
+	}
+	return err
 }
 
 func (s *SubscriptionWatcher) fetchSubscriptions(ctx context.Context, done chan struct{}, t time.Time) chan ResourceSubscription {
@@ -164,19 +339,19 @@ func (s *SubscriptionWatcher) fetchSubscriptions(ctx context.Context, done chan
 	output := func() {
 		defer wg.Done()
 
-		s.logger.Debugln("fetchSubscriptions: start")
+		s.logger.Debug("fetchSubscriptions: start")
 
 		_, subscriptions, err := s.client.Subscription.List(ctx)
 		if err != nil {
 			subscriptions = []Subscription{}
 			if !errors.Is(err, context.Canceled) {
-				s.logger.Errorf("fetchSubscriptions: fetching subscriptions: %s", err)
+				s.logger.Error("fetchSubscriptions: fetching subscriptions", "error", err)
 			}
 		}
 		for _, sub := range subscriptions {
 			ct, err := schema.GetContentType(*sub.ContentType)
 			if err != nil {
-				s.logger.Errorf("fetchSubscriptions: mapping contentType: %s", err)
+				s.logger.Error("fetchSubscriptions: mapping contentType", "error", err)
 				continue
 			}
 			select {
@@ -185,7 +360,7 @@ func (s *SubscriptionWatcher) fetchSubscriptions(ctx context.Context, done chan
 			case out <- ResourceSubscription{ct, t, sub}:
 			}
 		}
-		s.logger.Debugln("fetchSubscriptions: end")
+		s.logger.Debug("fetchSubscriptions: end")
 	}
 
 	wg.Add(1)
@@ -199,51 +374,87 @@ func (s *SubscriptionWatcher) fetchSubscriptions(ctx context.Context, done chan
 	return out
 }
 
-func (s *SubscriptionWatcher) fetchContent(ctx context.Context, done chan struct{}, res ResourceSubscription) chan ResourceContent {
+// fetchContent lists the content of the subscription since the last request.
+// The listed content and time windows are queued on cp, which advances lastRequestTime
+// once they are done; lastRequestTime is set as soon as content is sent if cp is nil.
+func (s *SubscriptionWatcher) fetchContent(ctx context.Context, done chan struct{}, res ResourceSubscription, cp *checkpoint) chan ResourceContent {
 	var wg sync.WaitGroup
 	out := make(chan ResourceContent)
 
 	output := func(sub ResourceSubscription) {
 		defer wg.Done()
 
-		ctLogger := s.logger.WithField("content-type", sub.ContentType.String())
-		ctLogger.Debugln("fetchContent: start")
+		ctLogger := s.logger.With("content-type", sub.ContentType.String())
+		ctLogger.Debug("fetchContent: start")
 
 		end := sub.RequestTime
-		ctLogger.Debugf("fetchContent: request.RequestTime: %s", sub.RequestTime.String())
+		ctLogger.Debug("fetchContent: request.RequestTime", "request-time", sub.RequestTime)
+
+		if cp != nil && s.config.AtLeastOnce {
+			// blobs not acknowledged in time are emitted again once listed below
+			cp.expire(time.Now().Add(-time.Duration(s.config.AckTimeoutSeconds) * time.Second))
+		}
 
+		// lastRequestTime may lag behind the windows already listed
+		var listed time.Time
 		for {
-			lastRequestTime := s.getLastRequestTime(sub.ContentType)
-			ctLogger.Debugf("fetchContent: got lastRequestTime: %s", lastRequestTime.String())
+			last, err := s.Checkpoint(ctx, *sub.ContentType)
+			if err != nil {
+				ctLogger.Error("fetchContent: could not get checkpoint", "error", err)
+				return
+			}
+			lastRequestTime := last.LastRequestTime
+			ctLogger.Debug("fetchContent: got lastRequestTime", "last-request-time", lastRequestTime)
 
 			start := lastRequestTime
-			start, end = s.getTimeWindow(sub.RequestTime, start, end)
+			if listed.After(start) {
+				start = listed
+			}
+			start, end = s.getTimeWindow(sub.ContentType, sub.RequestTime, start, end)
 
-			ctLogger.Debugf("fetchContent: got timewindow start: %s", start.String())
-			ctLogger.Debugf("fetchContent: got timewindow end: %s", end.String())
+			ctLogger.Debug("fetchContent: got timewindow", "start", start, "end", end)
 
-			_, content, err := s.client.Content.List(ctx, sub.ContentType, start, end)
+			cycleCtx, span := s.client.telemetry.start(ctx, operationWatcherFetchContent, sub.ContentType)
+			_, content, err := s.client.Content.List(cycleCtx, sub.ContentType, start, end)
+			span.end(cycleCtx, nil, err, attrBlobCount.Int(len(content)))
 			if err != nil {
-				if !errors.Is(err, context.Canceled) {
-					ctLogger.Errorf("fetchContent: could not fetch content: %s", err)
+				switch {
+				case errors.Is(err, context.Canceled):
+				case errors.Is(err, ErrSubscriptionNotEnabled):
+					ctLogger.Warn("fetchContent: subscription is not enabled", "error", err)
+				default:
+					ctLogger.Error("fetchContent: could not fetch content", "error", err)
 				}
 				return
 			}
+			s.client.telemetry.blobs.Add(ctx, int64(len(content)), metric.WithAttributes(attrContentType.String(sub.ContentType.String())))
 			for _, c := range content {
+				if cp != nil && !cp.addBlob(c.ContentID) {
+					ctLogger.Debug("fetchContent: content already in flight", "content-id", c.ContentID)
+					continue
+				}
 				select {
 				case <-done:
 					return
 				case out <- ResourceContent{sub.ContentType, sub.RequestTime, c}:
 				}
 			}
-			s.setLastRequestTime(sub.ContentType, end)
-			ctLogger.Debugf("fetchContent: set lastRequestTime: %s", end.String())
+			listed = end
+			if cp != nil {
+				cp.addWindow(end)
+			} else {
+				if err := setLastRequestTime(ctx, s.State, *sub.ContentType, end); err != nil {
+					ctLogger.Error("fetchContent: could not set lastRequestTime", "error", err)
+				} else {
+					ctLogger.Debug("fetchContent: set lastRequestTime", "last-request-time", end)
+				}
+			}
 
 			if !end.Before(sub.RequestTime) {
 				break
 			}
 		}
-		ctLogger.Debugln("fetchContent: end")
+		ctLogger.Debug("fetchContent: end")
 	}
 
 	wg.Add(1)
@@ -257,54 +468,152 @@ func (s *SubscriptionWatcher) fetchContent(ctx context.Context, done chan struct
 	return out
 }
 
-func (s *SubscriptionWatcher) fetchAudits(ctx context.Context, done chan struct{}, contentCh chan ResourceContent) chan ResourceAudits {
+// auditsJob is a content blob whose audits are fetched by fetchAudits.
+type auditsJob struct {
+	res     ResourceContent
+	created time.Time
+
+	// fetched is closed once records and err are set, nil if the blob
+	// is fetched by the emitting goroutine itself.
+	fetched chan struct{}
+	records []ResourceAudits
+	err     error
+}
+
+// fetchAudits fetches the audits of the content received on contentCh,
+// with up to concurrency blobs fetched at once.
+//
+// Records are emitted in the order content is listed: with a concurrency above 1,
+// the records of a blob are buffered until the blobs listed before it are emitted.
+// Blobs are sealed on cp once emitted, so that lastContentCreated only advances
+// once a blob and the ones listed before it are done, and a blob still in flight
+// is fetched again after a restart. With AtLeastOnce, a blob whose records could
+// not be fetched is released instead, as is a blob not acknowledged within
+// AckTimeoutSeconds, and fetched again once listed again, whatever lastContentCreated.
+func (s *SubscriptionWatcher) fetchAudits(ctx context.Context, done chan struct{}, contentCh chan ResourceContent, concurrency int, cp *checkpoint) chan ResourceAudits {
 	var wg sync.WaitGroup
 	out := make(chan ResourceAudits)
+	ordered := make(chan *auditsJob, concurrency)
+	pending := make(chan *auditsJob)
 
-	output := func(ch <-chan ResourceContent) {
-		defer wg.Done()
+	// content is filtered by a single goroutine, which queues jobs
+	// in the order content is listed
+	filter := func(ch <-chan ResourceContent) {
+		defer close(ordered)
+		defer close(pending)
 
+		var lastQueued time.Time
 		for res := range ch {
-			ctLogger := s.logger.WithField("content-type", res.ContentType.String())
-			ctLogger.Debugln("fetchAudits: start")
-
-			lastContentCreated := s.getLastContentCreated(res.ContentType)
-			ctLogger.Debugf("fetchAudits: got lastContentCreated: %s", lastContentCreated.String())
+			ctLogger := s.logger.With("content-type", res.ContentType.String())
+			ctLogger.Debug("fetchAudits: start")
 
 			created, err := time.ParseInLocation(CreatedDatetimeFormat, res.Content.ContentCreated, time.Local)
 			if err != nil {
-				ctLogger.Errorf("fetchAudits: could not parse ContentCreated: %s", err)
+				ctLogger.Error("fetchAudits: could not parse ContentCreated", "error", err)
+				cp.seal(res.Content.ContentID, time.Time{})
 				continue
 			}
-			ctLogger.Debugf("fetchAudits: content found: %s", created.String())
-			if !created.After(lastContentCreated) {
-				ctLogger.Debugf("fetchAudits: content skipped: last[%s] GT current[%s]", lastContentCreated.String(), created.String())
-				continue
+			ctLogger.Debug("fetchAudits: content found", "content-created", created)
+
+			switch {
+			case cp.resent(res.Content.ContentID):
+				// the blob was released, it is not done yet
+				ctLogger.Debug("fetchAudits: content emitted again", "content-id", res.Content.ContentID)
+			case s.config.Deduplicate:
+				if s.isDuplicateContent(ctx, res) {
+					ctLogger.Debug("fetchAudits: duplicate content skipped", "content-id", res.Content.ContentID)
+					cp.skip(res.Content.ContentID, created)
+					continue
+				}
+			default:
+				last, err := s.Checkpoint(ctx, *res.ContentType)
+				if err != nil {
+					// the content is fetched rather than lost
+					ctLogger.Error("fetchAudits: could not get checkpoint", "error", err)
+				}
+				lastContentCreated := last.LastContentCreated
+				if lastQueued.After(lastContentCreated) {
+					lastContentCreated = lastQueued
+				}
+				ctLogger.Debug("fetchAudits: got lastContentCreated", "last-content-created", lastContentCreated)
+				if !created.After(lastContentCreated) {
+					ctLogger.Debug("fetchAudits: content skipped", "last-content-created", lastContentCreated, "content-created", created)
+					cp.seal(res.Content.ContentID, created)
+					continue
+				}
+				lastQueued = created
 			}
-			s.setLastContentCreated(res.ContentType, created)
-			ctLogger.Debugf("fetchAudits: set lastContentCreated: %s", created.String())
 
-			ctLogger.Debugln("fetchAudits: content fetching..")
-			_, audits, err := s.client.Audit.List(ctx, res.Content.ContentID, s.config.AddExtendedSchemas)
-			if err != nil {
-				if !errors.Is(err, context.Canceled) {
-					ctLogger.Errorf("fetchAudits: could not fetch audits: %s", err)
-				}
+			job := &auditsJob{res: res, created: created}
+			if concurrency > 1 {
+				job.fetched = make(chan struct{})
+			}
+			select {
+			case <-done:
+				return
+			case ordered <- job:
+			}
+			if job.fetched == nil {
 				continue
 			}
-			for _, a := range audits {
-				select {
-				case <-done:
+			select {
+			case <-done:
+				return
+			case pending <- job:
+			}
+		}
+	}
+
+	// fetchers buffer the records of a blob until the emitter reaches it
+	fetcher := func(ch <-chan *auditsJob) {
+		defer wg.Done()
+
+		for job := range ch {
+			s.logger.Debug("fetchAudits: content fetching..", "content-type", job.res.ContentType.String(), "content-id", job.res.Content.ContentID)
+			job.err = s.streamAudits(ctx, job.res, func(a ResourceAudits) error {
+				job.records = append(job.records, a)
+				return nil
+			})
+			close(job.fetched)
+		}
+	}
+
+	emitter := func(ch <-chan *auditsJob) {
+		defer wg.Done()
+
+		for job := range ch {
+			ctLogger := s.logger.With("content-type", job.res.ContentType.String(), "content-id", job.res.Content.ContentID)
+			err := s.emitJob(ctx, s.sendTracked(ctx, done, out, cp, job.res.Content.ContentID), job)
+			if err != nil {
+				switch {
+				case errors.Is(err, errWatcherDone), errors.Is(err, context.Canceled):
+					// not done, the blob is fetched again after a restart
 					return
-				case out <- ResourceAudits{res.ContentType, res.RequestTime, a}:
+				case errors.Is(err, ErrContentExpired), errors.Is(err, ErrContentNotFound):
+					ctLogger.Warn("fetchAudits: content dropped", "error", err)
+				case s.config.AtLeastOnce:
+					// not done, the blob is fetched again once listed again
+					ctLogger.Error("fetchAudits: could not fetch audits, content released", "error", err)
+					cp.release(job.res.Content.ContentID)
+					continue
+				default:
+					ctLogger.Error("fetchAudits: could not fetch audits", "error", err)
 				}
 			}
-			ctLogger.Debugln("fetchAudits: end")
+			cp.seal(job.res.Content.ContentID, job.created)
+			ctLogger.Debug("fetchAudits: end")
 		}
 	}
 
+	go filter(contentCh)
 	wg.Add(1)
-	go output(contentCh)
+	go emitter(ordered)
+	if concurrency > 1 {
+		wg.Add(concurrency)
+		for i := 0; i < concurrency; i++ {
+			go fetcher(pending)
+		}
+	}
 
 	go func() {
 		wg.Wait()
@@ -314,13 +623,163 @@ func (s *SubscriptionWatcher) fetchAudits(ctx context.Context, done chan struct{
 	return out
 }
 
-func (s *SubscriptionWatcher) getTimeWindow(requestTime, start, end time.Time) (time.Time, time.Time) {
+// emitJob passes the records of the job to send, fetching them first if no fetcher did.
+func (s *SubscriptionWatcher) emitJob(ctx context.Context, send func(ResourceAudits) error, job *auditsJob) error {
+	if job.fetched == nil {
+		s.logger.Debug("fetchAudits: content fetching..", "content-type", job.res.ContentType.String(), "content-id", job.res.Content.ContentID)
+		return s.streamAudits(ctx, job.res, send)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-job.fetched:
+	}
+	for _, a := range job.records {
+		if err := send(a); err != nil {
+			return err
+		}
+	}
+	job.records = nil
+	return job.err
+}
+
+// sendAudits returns a function sending records to out, until done is closed.
+func sendAudits(done chan struct{}, out chan ResourceAudits) func(ResourceAudits) error {
+	return func(a ResourceAudits) error {
+		select {
+		case <-done:
+			return errWatcherDone
+		case out <- a:
+			return nil
+		}
+	}
+}
+
+// sendTracked returns a function sending the records of a blob to out until done is closed,
+// counting them as pending on cp, if not nil, until acknowledged.
+// Records are acknowledged once sent, unless the watcher runs with AtLeastOnce.
+func (s *SubscriptionWatcher) sendTracked(ctx context.Context, done chan struct{}, out chan ResourceAudits, cp *checkpoint, id string) func(ResourceAudits) error {
+	send := sendAudits(done, out)
+	return func(a ResourceAudits) error {
+		if s.config.Deduplicate && s.isDuplicateRecord(ctx, a, id) {
+			return nil
+		}
+		var ack func()
+		if cp != nil {
+			ack = cp.track(id)
+		}
+		a.ack = s.recordAck(ctx, a, ack)
+		if err := send(a); err != nil {
+			if s.config.Deduplicate {
+				s.releaseRecord(a)
+			}
+			return err
+		}
+		if !s.config.AtLeastOnce {
+			a.Ack()
+		}
+		return nil
+	}
+}
+
+// streamAudits fetches the audits of a content blob, passing them to fn as soon as they are decoded.
+// The error returned by fn, if any, stops the fetch and is returned.
+func (s *SubscriptionWatcher) streamAudits(ctx context.Context, res ResourceContent, fn func(ResourceAudits) error) error {
+	var records int64
+	emit := func(a interface{}) error {
+		record := ResourceAudits{
+			ContentType: res.ContentType,
+			RequestTime: res.RequestTime,
+			TenantID:    s.client.tenantID,
+			AuditRecord: a,
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		records++
+		return nil
+	}
+
+	var err error
+	addExtendedSchemas := s.contentTypeSettings(res.ContentType).addExtendedSchemas
+	fetchCtx, span := s.client.telemetry.start(ContextWithContentType(ctx, res.ContentType), operationWatcherFetchAudits, res.ContentType)
+	if res.Content.ContentURI != "" {
+		_, err = s.client.Audit.StreamURI(fetchCtx, res.Content.ContentURI, addExtendedSchemas, emit)
+	} else {
+		_, err = s.client.Audit.Stream(fetchCtx, res.Content.ContentID, addExtendedSchemas, emit)
+	}
+	span.end(fetchCtx, nil, err, attrContentID.String(res.Content.ContentID), attrRecordCount.Int64(records))
+	s.client.telemetry.records.Add(ctx, records, metric.WithAttributes(attrContentType.String(res.ContentType.String())))
+	return err
+}
+
+// contentTypeSettings returns the settings of the content type,
+// falling back to the watcher settings if it is not watched.
+func (s *SubscriptionWatcher) contentTypeSettings(ct *schema.ContentType) contentTypeSettings {
+	if settings, ok := s.settings[*ct]; ok {
+		return settings
+	}
+	settings, _ := ContentTypeConfig{
+		LookBehindMinutes:     s.config.LookBehindMinutes,
+		TickerIntervalSeconds: s.config.TickerIntervalSeconds,
+		AddExtendedSchemas:    Bool(s.config.AddExtendedSchemas),
+	}.settings()
+	return settings
+}
+
+// watches reports whether the content type is watched.
+func (s *SubscriptionWatcher) watches(ct *schema.ContentType) bool {
+	_, ok := s.settings[*ct]
+	return ok
+}
+
+// tickerInterval returns the shortest interval between two polls of the watched content types.
+func (s *SubscriptionWatcher) tickerInterval() time.Duration {
+	var interval time.Duration
+	for _, settings := range s.settings {
+		if interval == 0 || settings.tickerInterval < interval {
+			interval = settings.tickerInterval
+		}
+	}
+	return interval
+}
+
+// pollSchedule tells which content types are due on a tick of the shortest ticker
+// interval: content types polled less often are skipped until their own interval elapses.
+type pollSchedule struct {
+	settings map[schema.ContentType]contentTypeSettings
+	tick     time.Duration
+	last     map[schema.ContentType]time.Time
+}
+
+// newPollSchedule returns the poll schedule of the watched content types.
+func (s *SubscriptionWatcher) newPollSchedule() *pollSchedule {
+	return &pollSchedule{
+		settings: s.settings,
+		tick:     s.tickerInterval(),
+		last:     make(map[schema.ContentType]time.Time),
+	}
+}
+
+// due reports whether the content type must be polled on the tick at t.
+func (p *pollSchedule) due(ct schema.ContentType, t time.Time) bool {
+	last, ok := p.last[ct]
+	return !ok || t.Sub(last) >= p.settings[ct].tickerInterval-p.tick/2
+}
+
+// polled records that the content type was polled on the tick at t.
+func (p *pollSchedule) polled(ct schema.ContentType, t time.Time) {
+	p.last[ct] = t
+}
+
+func (s *SubscriptionWatcher) getTimeWindow(ct *schema.ContentType, requestTime, start, end time.Time) (time.Time, time.Time) {
 	if start.Equal(end) {
 		end = requestTime
 	}
 
 	delta := end.Sub(start)
-	lookbehindDelta := time.Duration(s.config.LookBehindMinutes) * time.Minute
+	lookbehindDelta := s.contentTypeSettings(ct).lookBehind
 
 	switch {
 	case start.IsZero(), start.After(end), delta < lookbehindDelta:
@@ -364,5 +823,9 @@ type ResourceContent struct {
 type ResourceAudits struct {
 	ContentType *schema.ContentType
 	RequestTime time.Time
+	TenantID    string
 	AuditRecord interface{}
+
+	// ack acknowledges the record, nil if not tracked.
+	ack func()
 }
@@ -0,0 +1,217 @@
+package office365
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestSubscriptionWatcherConfig(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Config  SubscriptionWatcherConfig
+		WantErr bool
+	}{
+		{"defaults", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5}, false},
+		{"no lookbehind", SubscriptionWatcherConfig{TickerIntervalSeconds: 5}, true},
+		{"long interval", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 7200}, true},
+		{"negative concurrency", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, Concurrency: -1}, true},
+		{"overrides", SubscriptionWatcherConfig{ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral: {LookBehindMinutes: 5, TickerIntervalSeconds: 5},
+		}}, false},
+		{"invalid override", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral: {LookBehindMinutes: 48 * 60},
+		}}, true},
+		{"invalid content type", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.ContentType(-1): {},
+		}}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := NewSubscriptionWatcher(nil, tc.Config, NewMemoryState(), nil, nil)
+			if (err != nil) != tc.WantErr {
+				t.Errorf("got error %v but want error %v", err, tc.WantErr)
+			}
+		})
+	}
+}
+
+// newTestServer returns a fake API server, closed once the test ends,
+// and a client of "tenant" using it.
+func newTestServer(t *testing.T, opts ...ClientOption) (*office365test.Server, *Client) {
+	t.Helper()
+	server := office365test.NewServer()
+	t.Cleanup(server.Close)
+	client := NewClient(nil, "tenant", "", opts...)
+	client.BaseURL = server.BaseURL()
+	return server, client
+}
+
+// addRecords adds a blob holding records with the provided IDs, returning its content ID.
+func addRecords(t *testing.T, server *office365test.Server, ct schema.ContentType, created time.Time, ids ...string) string {
+	t.Helper()
+	var records []interface{}
+	for _, id := range ids {
+		records = append(records, schema.AuditRecord{ID: String(id)})
+	}
+	contentID, err := server.AddContent("tenant", ct, created, records...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contentID
+}
+
+// runTestWatcher runs a watcher until ctx is done or the test ends.
+// It returns the function waiting for Run to return, which returns its error.
+func runTestWatcher(ctx context.Context, t *testing.T, client *Client, conf SubscriptionWatcherConfig, state State, h ResourceHandler) (*SubscriptionWatcher, func() error) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	watcher, err := NewSubscriptionWatcher(client, conf, state, h, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var runErr error
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runErr = watcher.Run(ctx)
+	}()
+	wait := func() error {
+		<-stopped
+		return runErr
+	}
+	t.Cleanup(func() {
+		cancel()
+		_ = wait()
+	})
+	return watcher, wait
+}
+
+// receiveRecords receives n records from ch, failing the test if they are not received within 5 seconds.
+func receiveRecords(t *testing.T, ch <-chan ResourceAudits, n int) []ResourceAudits {
+	t.Helper()
+	var records []ResourceAudits
+	timeout := time.After(5 * time.Second)
+	for len(records) < n {
+		select {
+		case res := <-ch:
+			records = append(records, res)
+		case <-timeout:
+			t.Fatalf("got %d records but want %d", len(records), n)
+		}
+	}
+	return records
+}
+
+// receiveIDs receives n records from ch and returns their IDs.
+func receiveIDs(t *testing.T, ch <-chan ResourceAudits, n int) []string {
+	t.Helper()
+	var ids []string
+	for _, res := range receiveRecords(t, ch, n) {
+		ids = append(ids, auditRecordID(res.AuditRecord))
+	}
+	return ids
+}
+
+// restartState returns the state written and read back, as after a restart.
+func restartState(t *testing.T, state *MemoryState) *MemoryState {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := state.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemoryState()
+	if err := restored.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return restored
+}
+
+func TestSubscriptionWatcherContentTypes(t *testing.T) {
+	server, client := newTestServer(t)
+
+	now := time.Now()
+	for _, ct := range []schema.ContentType{schema.AuditGeneral, schema.AuditExchange, schema.DLPAll} {
+		server.StartSubscription("tenant", ct)
+		server.GenerateContent("tenant", ct, now.Add(-50*time.Minute), now.Add(-5*time.Minute), 5*time.Minute, 2)
+	}
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	conf := SubscriptionWatcherConfig{
+		LookBehindMinutes:     60,
+		TickerIntervalSeconds: 1,
+		ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral:  {Concurrency: 3},
+			schema.AuditExchange: {LookBehindMinutes: 32},
+		},
+	}
+	runTestWatcher(context.Background(), t, client, conf, NewMemoryState(), handler)
+
+	// 9 blobs of 2 records for AuditGeneral, 5 of them within 32 minutes for AuditExchange
+	got := make(map[schema.ContentType]int)
+	for _, res := range receiveRecords(t, handler.ch, 28) {
+		got[*res.ContentType]++
+	}
+	testDeep(t, got, map[schema.ContentType]int{schema.AuditGeneral: 18, schema.AuditExchange: 10})
+}
+
+func TestSubscriptionWatcherConcurrency(t *testing.T) {
+	// the first blob is held until the others are fetched
+	var held string
+	release := make(chan struct{})
+	fetched := make(chan string, 3)
+	hold := func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+		if info.Operation == OperationAuditFetch && strings.HasSuffix(req.URL.Path, held) {
+			<-release
+		}
+		resp, err := next(req)
+		fetched <- req.URL.Path
+		return resp, err
+	}
+	server, client := newTestServer(t, WithInterceptors(hold))
+
+	ct := schema.AuditGeneral
+	now := time.Now()
+	server.StartSubscription("tenant", ct)
+	for i, id := range []string{"1", "2", "3"} {
+		contentID := addRecords(t, server, ct, now.Add(time.Duration(i-3)*10*time.Minute), id)
+		if held == "" {
+			held = contentID
+		}
+	}
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	state := NewMemoryState()
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 60, Concurrency: 3}
+	runTestWatcher(context.Background(), t, client, conf, state, handler)
+
+	// subscriptions and content are listed, then the last two blobs fetched
+	for i := 0; i < 4; i++ {
+		select {
+		case <-fetched:
+		case <-time.After(5 * time.Second):
+			t.Fatal("blobs were not fetched concurrently")
+		}
+	}
+	select {
+	case res := <-handler.ch:
+		t.Fatalf("got record %#v before the first blob", res.AuditRecord)
+	default:
+	}
+	if last := getCheckpoint(t, state, ct).LastContentCreated; !last.IsZero() {
+		t.Errorf("got lastContentCreated %s while the first blob is in flight", last)
+	}
+
+	close(release)
+	testDeep(t, receiveIDs(t, handler.ch, 3), []string{"1", "2", "3"})
+}
@@ -0,0 +1,111 @@
+package office365
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+var defaultWebhookMaxBodySize int64 = 1 << 20
+
+// WebhookHandler is an http.Handler receiving the notifications sent to the
+// webhook of a subscription, see SubscriptionService.Start.
+// It answers the validation request sent when the webhook is registered, and
+// hands the content of every notification to Handle.
+//
+// Microsoft API Reference: https://docs.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference#receiving-notifications
+//
+// Microsoft disables webhooks failing too often, so a notification is answered
+// with a 200 status once handled, and with a 503 status when Handle fails,
+// for it to be sent again later.
+type WebhookHandler struct {
+	// AuthID must match the Webhook-AuthID header of the requests.
+	// The header is not checked if empty.
+	AuthID string
+	// Handle is called with the content of every notification, within the request.
+	// It should block until the content is accepted, which makes Microsoft slow
+	// down when the consumer falls behind.
+	Handle func(ctx context.Context, content []Content) error
+	// MaxBodySize limits the size of the notifications. It defaults to 1MB.
+	MaxBodySize int64
+}
+
+// NewWebhookHandler returns a WebhookHandler checking the provided AuthID and calling fn for every notification.
+func NewWebhookHandler(authID string, fn func(ctx context.Context, content []Content) error) *WebhookHandler {
+	return &WebhookHandler{AuthID: authID, Handle: fn}
+}
+
+// NewWebhookChanHandler returns a WebhookHandler checking the provided AuthID and sending
+// the content of every notification to ch.
+// A notification is acknowledged once all of its content is sent; if the request
+// ends before that, it is answered with a 503 status and sent again by Microsoft,
+// so that content may be received twice.
+func NewWebhookChanHandler(authID string, ch chan<- Content) *WebhookHandler {
+	return NewWebhookHandler(authID, func(ctx context.Context, content []Content) error {
+		for _, c := range content {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- c:
+			}
+		}
+		return nil
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.AuthID != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Webhook-AuthID")), []byte(h.AuthID)) != 1 {
+		http.Error(w, "invalid Webhook-AuthID", http.StatusUnauthorized)
+		return
+	}
+
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultWebhookMaxBodySize
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "notification too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "could not read notification", http.StatusBadRequest)
+		return
+	}
+
+	// the validation request holds a validationCode object instead of an array
+	if r.Header.Get("Webhook-ValidationCode") != "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var content []Content
+	if err := json.Unmarshal(data, &content); err != nil {
+		var validation struct {
+			ValidationCode string `json:"validationCode"`
+		}
+		if json.Unmarshal(data, &validation) == nil && validation.ValidationCode != "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
+	}
+	if len(content) > 0 && h.Handle != nil {
+		if err := h.Handle(r.Context(), content); err != nil {
+			http.Error(w, "notification could not be handled", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
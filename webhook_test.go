@@ -0,0 +1,97 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestWebhookHandlerSubscription(t *testing.T) {
+	ch := make(chan Content, 1)
+	hook := httptest.NewServer(NewWebhookChanHandler("secret", ch))
+	defer hook.Close()
+
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+
+	ct := schema.AuditExchange
+	_, _, err := client.Subscription.Start(context.Background(), &ct, &Webhook{Address: String(hook.URL), AuthID: String("secret")})
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+
+	id, err := server.AddContent("tenant", ct, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-ch:
+		if c.ContentID != id || c.ContentType != ct.String() || c.TenantID != "tenant" {
+			t.Errorf("got content %+v but want %s", c, id)
+		}
+	default:
+		t.Fatal("no content received")
+	}
+}
+
+func TestWebhookHandlerStatus(t *testing.T) {
+	handler := NewWebhookHandler("secret", func(ctx context.Context, content []Content) error {
+		if content[0].ContentID == "failing" {
+			return errors.New("consumer failure")
+		}
+		return nil
+	})
+
+	cases := []struct {
+		Name       string
+		Method     string
+		AuthID     string
+		Validation string
+		Body       string
+		WantStatus int
+	}{
+		{"validation", "POST", "secret", "code", `{"validationCode": "code"}`, http.StatusOK},
+		{"notification", "POST", "secret", "", `[{"contentId": "abc"}]`, http.StatusOK},
+		{"wrong AuthID", "POST", "other", "", `[{"contentId": "abc"}]`, http.StatusUnauthorized},
+		{"wrong method", "GET", "secret", "", ``, http.StatusMethodNotAllowed},
+		{"invalid body", "POST", "secret", "", `{"contentId"`, http.StatusBadRequest},
+		{"handler failure", "POST", "secret", "", `[{"contentId": "failing"}]`, http.StatusServiceUnavailable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.Method, "/webhook", strings.NewReader(tc.Body))
+			r.Header.Set("Webhook-AuthID", tc.AuthID)
+			if tc.Validation != "" {
+				r.Header.Set("Webhook-ValidationCode", tc.Validation)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.WantStatus {
+				t.Errorf("got status %d but want %d", w.Code, tc.WantStatus)
+			}
+		})
+	}
+}
+
+func TestWebhookChanHandlerBackpressure(t *testing.T) {
+	ch := make(chan Content)
+	handler := NewWebhookChanHandler("", ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("POST", "/webhook", strings.NewReader(`[{"contentId": "abc"}]`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d but want %d when nobody receives the content", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,302 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// Layouts accepted for webhook expirations, the API not enforcing one.
+var webhookExpirationFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	CreatedDatetimeFormat,
+}
+
+// WebhookAction is an action taken by a WebhookMaintainer.
+type WebhookAction string
+
+// Actions taken by WebhookMaintainer.
+const (
+	// WebhookActionRenew starts the subscription again with a later expiration.
+	WebhookActionRenew WebhookAction = "renew"
+	// WebhookActionReenable starts the subscription again for enabling a disabled webhook.
+	WebhookActionReenable WebhookAction = "reenable"
+)
+
+// WebhookEvent reports an action taken by a WebhookMaintainer.
+type WebhookEvent struct {
+	ContentType schema.ContentType
+	Action      WebhookAction
+	// Reason describes why the action was taken, e.g. "webhook is disabled".
+	Reason string
+	// Expiration is the expiration requested for the webhook, zero for none.
+	Expiration time.Time
+	// Attempt is the number of attempts made for the action, this one included.
+	Attempt int
+	// Err is the error that occurred, nil if the action succeeded.
+	Err error
+	// RetryAt is the time a failed action is attempted again.
+	RetryAt time.Time
+}
+
+// WebhookMaintainerConfig configures a WebhookMaintainer.
+// Zero values are replaced by the defaults documented on each field.
+type WebhookMaintainerConfig struct {
+	// CheckInterval is the interval between two checks of the subscriptions. It defaults to 1 hour.
+	CheckInterval time.Duration
+	// RenewBefore is how long before its expiration a webhook is renewed. It defaults to 24 hours.
+	RenewBefore time.Duration
+	// Lifetime is the time a renewed webhook is valid for.
+	// Zero renews webhooks without an expiration.
+	Lifetime time.Duration
+	// MinBackoff is the delay before retrying a failed action, doubling on every attempt.
+	// It defaults to 1 minute.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts. It defaults to CheckInterval.
+	MaxBackoff time.Duration
+}
+
+// WebhookMaintainer keeps the webhooks of subscriptions working.
+// It periodically lists the subscriptions, renews the webhooks about to expire,
+// and re-enables the webhooks Microsoft disabled after failed notifications,
+// or which expired meanwhile.
+type WebhookMaintainer struct {
+	client *Client
+	config WebhookMaintainerConfig
+	logger *slog.Logger
+	events chan<- WebhookEvent
+
+	mu      sync.Mutex
+	retries map[schema.ContentType]*webhookRetry
+}
+
+// webhookRetry tracks the failed attempts of an action.
+type webhookRetry struct {
+	attempts int
+	at       time.Time
+}
+
+// NewWebhookMaintainer returns a new maintainer that uses the provided client
+// for querying the API, and reports every action taken on events if not nil.
+// The default slog logger is used if l is nil.
+func NewWebhookMaintainer(client *Client, conf WebhookMaintainerConfig, events chan<- WebhookEvent, l *slog.Logger) (*WebhookMaintainer, error) {
+	if conf.CheckInterval < 0 || conf.RenewBefore < 0 || conf.Lifetime < 0 || conf.MinBackoff < 0 || conf.MaxBackoff < 0 {
+		return nil, fmt.Errorf("durations must not be negative")
+	}
+	if conf.CheckInterval == 0 {
+		conf.CheckInterval = time.Hour
+	}
+	if conf.RenewBefore == 0 {
+		conf.RenewBefore = 24 * time.Hour
+	}
+	if conf.Lifetime > 0 && conf.Lifetime <= conf.RenewBefore {
+		return nil, fmt.Errorf("lifetime must be greater than renewBefore")
+	}
+	if conf.MinBackoff == 0 {
+		conf.MinBackoff = time.Minute
+	}
+	if conf.MaxBackoff == 0 {
+		conf.MaxBackoff = conf.CheckInterval
+	}
+
+	maintainer := &WebhookMaintainer{
+		client:  client,
+		config:  conf,
+		logger:  loggerOrDefault(l),
+		events:  events,
+		retries: make(map[schema.ContentType]*webhookRetry),
+	}
+	return maintainer, nil
+}
+
+// Run checks the subscriptions every CheckInterval, and sooner when a failed
+// action is due for a retry, until the context is done.
+func (m *WebhookMaintainer) Run(ctx context.Context) error {
+	m.logger.Info("start webhook maintainer")
+	m.logger.Info("using config", "config", m.config)
+
+	next := time.Now()
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.logger.Info("end webhook maintainer")
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		m.Check(ctx)
+		next = time.Now().Add(m.config.CheckInterval)
+		if retryAt := m.nextRetry(); !retryAt.IsZero() && retryAt.Before(next) {
+			next = retryAt
+		}
+	}
+}
+
+// Check lists the subscriptions once and acts on the webhooks needing it.
+// Actions failing recently are skipped until their backoff elapses.
+func (m *WebhookMaintainer) Check(ctx context.Context) {
+	m.logger.Debug("check: start")
+
+	_, subscriptions, err := m.client.Subscription.List(ctx)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			m.logger.Error("check: fetching subscriptions", "error", err)
+		}
+		return
+	}
+
+	now := time.Now()
+	for _, sub := range subscriptions {
+		if sub.ContentType == nil {
+			continue
+		}
+		ct, err := schema.GetContentType(*sub.ContentType)
+		if err != nil {
+			m.logger.Error("check: mapping contentType", "error", err)
+			continue
+		}
+		ctLogger := m.logger.With("content-type", ct.String())
+
+		action, reason := m.webhookAction(sub, now)
+		if action == "" {
+			m.clearRetry(*ct)
+			continue
+		}
+		attempt, due := m.attempt(*ct, now)
+		if !due {
+			ctLogger.Debug("check: waiting for backoff", "action", string(action))
+			continue
+		}
+
+		event := WebhookEvent{ContentType: *ct, Action: action, Reason: reason, Attempt: attempt}
+		webhook := Webhook{Address: sub.Webhook.Address, AuthID: sub.Webhook.AuthID}
+		switch expiration, ok := parseWebhookExpiration(stringValue(sub.Webhook.Expiration)); {
+		case m.config.Lifetime > 0:
+			event.Expiration = now.Add(m.config.Lifetime).UTC().Truncate(time.Second)
+			webhook.Expiration = String(event.Expiration.Format(time.RFC3339))
+		case action == WebhookActionReenable && ok && expiration.After(now):
+			// a disabled webhook keeps its expiration
+			event.Expiration = expiration
+			webhook.Expiration = sub.Webhook.Expiration
+		}
+
+		_, _, event.Err = m.client.Subscription.Start(ctx, ct, &webhook)
+		if event.Err != nil {
+			if errors.Is(event.Err, context.Canceled) {
+				return
+			}
+			event.RetryAt = m.failRetry(*ct, attempt)
+			ctLogger.Error("check: could not apply webhook action", "action", string(action), "reason", reason, "attempt", attempt, "retry-at", event.RetryAt, "error", event.Err)
+		} else {
+			m.clearRetry(*ct)
+			ctLogger.Info("check: webhook action applied", "action", string(action), "reason", reason, "expiration", event.Expiration)
+		}
+		m.emit(ctx, event)
+	}
+	m.logger.Debug("check: end")
+}
+
+// webhookAction returns the action the webhook of the subscription needs,
+// and why, or an empty action if none.
+func (m *WebhookMaintainer) webhookAction(sub Subscription, now time.Time) (WebhookAction, string) {
+	if sub.Webhook == nil || stringValue(sub.Webhook.Address) == "" ||
+		!strings.EqualFold(stringValue(sub.Status), "enabled") {
+		return "", ""
+	}
+
+	status := strings.ToLower(stringValue(sub.Webhook.Status))
+	switch status {
+	case "", "enabled":
+	default:
+		return WebhookActionReenable, "webhook is " + status
+	}
+
+	expiration, ok := parseWebhookExpiration(stringValue(sub.Webhook.Expiration))
+	switch {
+	case !ok:
+		return "", ""
+	case !expiration.After(now):
+		return WebhookActionReenable, "webhook expired at " + expiration.Format(time.RFC3339)
+	case expiration.Sub(now) <= m.config.RenewBefore:
+		return WebhookActionRenew, "webhook expires at " + expiration.Format(time.RFC3339)
+	}
+	return "", ""
+}
+
+// attempt returns the number of the next attempt for the content type,
+// and whether it is due.
+func (m *WebhookMaintainer) attempt(ct schema.ContentType, now time.Time) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.retries[ct]
+	if !ok {
+		return 1, true
+	}
+	return r.attempts + 1, !now.Before(r.at)
+}
+
+// failRetry records a failed attempt and returns the time of the next one.
+func (m *WebhookMaintainer) failRetry(ct schema.ContentType, attempt int) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policy := RetryPolicy{MinBackoff: m.config.MinBackoff, MaxBackoff: m.config.MaxBackoff}
+	r := &webhookRetry{attempts: attempt, at: time.Now().Add(policy.backoff(attempt))}
+	m.retries[ct] = r
+	return r.at
+}
+
+func (m *WebhookMaintainer) clearRetry(ct schema.ContentType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.retries, ct)
+}
+
+// nextRetry returns the time of the earliest retry, zero if none.
+func (m *WebhookMaintainer) nextRetry() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var next time.Time
+	for _, r := range m.retries {
+		if next.IsZero() || r.at.Before(next) {
+			next = r.at
+		}
+	}
+	return next
+}
+
+// emit reports the event, blocking until it is received or the context is done.
+func (m *WebhookMaintainer) emit(ctx context.Context, event WebhookEvent) {
+	if m.events == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case m.events <- event:
+	}
+}
+
+// parseWebhookExpiration parses the expiration of a webhook, false if there is none.
+func parseWebhookExpiration(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range webhookExpirationFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
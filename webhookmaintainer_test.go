@@ -0,0 +1,119 @@
+package office365
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestWebhookMaintainerCheck(t *testing.T) {
+	hook := httptest.NewServer(NewWebhookHandler("", nil))
+	defer hook.Close()
+
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	now := time.Now().UTC()
+	webhooks := map[schema.ContentType]string{
+		schema.AuditExchange:   now.Add(time.Hour).Format(time.RFC3339),
+		schema.AuditSharePoint: now.Add(72 * time.Hour).Format(time.RFC3339),
+		schema.AuditGeneral:    now.Add(72 * time.Hour).Format(time.RFC3339),
+	}
+	for ct, expiration := range webhooks {
+		ct := ct
+		webhook := &Webhook{Address: String(hook.URL), Expiration: String(expiration)}
+		if _, _, err := client.Subscription.Start(context.Background(), &ct, webhook); err != nil {
+			t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+		}
+	}
+	server.DisableWebhook("tenant", schema.AuditGeneral)
+
+	events := make(chan WebhookEvent, 10)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := WebhookMaintainerConfig{RenewBefore: 24 * time.Hour, Lifetime: 48 * time.Hour}
+	maintainer, err := NewWebhookMaintainer(client, conf, events, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maintainer.Check(context.Background())
+	close(events)
+	got := make(map[schema.ContentType]WebhookAction)
+	for e := range events {
+		if e.Err != nil {
+			t.Errorf("got error %v for %s", e.Err, e.ContentType.String())
+		}
+		got[e.ContentType] = e.Action
+	}
+	testDeep(t, got, map[schema.ContentType]WebhookAction{
+		schema.AuditExchange: WebhookActionRenew,
+		schema.AuditGeneral:  WebhookActionReenable,
+	})
+
+	_, subscriptions, err := client.Subscription.List(context.Background())
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.List: %v", err)
+	}
+	for _, sub := range subscriptions {
+		if *sub.Webhook.Status != "enabled" {
+			t.Errorf("got webhook status %s for %s", *sub.Webhook.Status, *sub.ContentType)
+		}
+		expiration, _ := parseWebhookExpiration(*sub.Webhook.Expiration)
+		if expiration.Sub(now) <= 24*time.Hour {
+			t.Errorf("got webhook expiration %s for %s", expiration, *sub.ContentType)
+		}
+	}
+}
+
+func TestWebhookMaintainerBackoff(t *testing.T) {
+	hook := httptest.NewServer(NewWebhookHandler("", nil))
+	defer hook.Close()
+
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+	client.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	ct := schema.AuditExchange
+	if _, _, err := client.Subscription.Start(context.Background(), &ct, &Webhook{Address: String(hook.URL)}); err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+	server.DisableWebhook("tenant", ct)
+	server.Inject(office365test.Fault{Operation: office365test.OperationSubscriptionStart, StatusCode: 500, Code: "AF50000", Times: 2})
+
+	events := make(chan WebhookEvent)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := WebhookMaintainerConfig{CheckInterval: time.Hour, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	maintainer, err := NewWebhookMaintainer(client, conf, events, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = maintainer.Run(ctx) }()
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		select {
+		case e := <-events:
+			if e.Action != WebhookActionReenable || e.Attempt != attempt {
+				t.Fatalf("got event %+v but want attempt %d to reenable", e, attempt)
+			}
+			if failed := attempt < 3; (e.Err != nil) != failed || e.RetryAt.IsZero() != !failed {
+				t.Errorf("got error %v and retry at %s on attempt %d", e.Err, e.RetryAt, attempt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event received for attempt %d", attempt)
+		}
+	}
+}
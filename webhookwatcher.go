@@ -0,0 +1,280 @@
+package office365
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/orlangure/go-office365/schema"
+)
+
+// defaultContentTTL is the retention of content blobs, used when a blob has no valid expiration.
+const defaultContentTTL = 7 * 24 * time.Hour
+
+// WebhookWatcher implements the Watcher interface.
+// It fetches audit records as soon as webhook notifications are received, see
+// NewWebhookChanHandler, instead of polling the API for new content.
+//
+// Notifications may be dropped by Microsoft, so content is still listed every
+// TickerIntervalSeconds over the last LookBehindMinutes, which can be far less
+// frequent than with a SubscriptionWatcher.
+// Content received through both paths is fetched once, the ID of fetched content
+// being kept in State until it expires. Up to Concurrency blobs of a content type
+// are fetched at once, their records being emitted as soon as they are decoded.
+type WebhookWatcher struct {
+	*SubscriptionWatcher
+
+	notifications <-chan Content
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+}
+
+// NewWebhookWatcher returns a new watcher that fetches the content received on
+// notifications, and uses the provided client for querying the API.
+// The configuration is validated as by NewSubscriptionWatcher and applies the same way,
+// TickerIntervalSeconds being the interval between two reconciliations of a content type
+// and Concurrency the number of its blobs fetched at once, whichever path they come from.
+// Content is always deduplicated, s must implement DedupState, Deduplicate also skipping
+// the records already emitted.
+// AtLeastOnce is not supported.
+// The default slog logger is used if l is nil.
+func NewWebhookWatcher(client *Client, conf SubscriptionWatcherConfig, notifications <-chan Content, s State, h ResourceHandler, l *slog.Logger) (*WebhookWatcher, error) {
+	if conf.AtLeastOnce {
+		return nil, fmt.Errorf("atLeastOnce is not supported by WebhookWatcher")
+	}
+	dedup, ok := s.(DedupState)
+	if !ok {
+		return nil, fmt.Errorf("WebhookWatcher requires a State implementing DedupState")
+	}
+	sw, err := NewSubscriptionWatcher(client, conf, s, h, l)
+	if err != nil {
+		return nil, err
+	}
+	sw.dedup = dedup
+	watcher := &WebhookWatcher{
+		SubscriptionWatcher: sw,
+		notifications:       notifications,
+		inflight:            make(map[string]struct{}),
+	}
+	return watcher, nil
+}
+
+// Run implements the Watcher interface.
+// The watcher keeps reconciling if the notifications channel is closed.
+func (w *WebhookWatcher) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	out := make(chan ResourceAudits)
+
+	w.logger.Info("start main")
+	w.logger.Info("using config", "config", w.config)
+
+	jobs := w.fetchers(ctx, done, out, &wg)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.receive(done, jobs)
+	}()
+	go func() {
+		defer wg.Done()
+		w.reconcile(ctx, done, jobs)
+	}()
+
+	// this goroutine is responsible for closing output channel
+	go func() {
+		wg.Wait()
+		close(out)
+		w.logger.Info("end main")
+	}()
+
+	// this goroutine is responsible for notifying
+	// everyone that we want to exit
+	go func() {
+		<-ctx.Done()
+		close(done)
+	}()
+
+	err := w.Handler.Handle(out)
+	return errors.Join(err, w.flush(context.WithoutCancel(ctx)))
+}
+
+// fetchers starts Concurrency goroutines per watched content type, fetching the content
+// sent on the channel of its content type until done is closed.
+func (w *WebhookWatcher) fetchers(ctx context.Context, done chan struct{}, out chan ResourceAudits, wg *sync.WaitGroup) map[schema.ContentType]chan ResourceContent {
+	jobs := make(map[schema.ContentType]chan ResourceContent, len(w.settings))
+	for ct, settings := range w.settings {
+		ch := make(chan ResourceContent)
+		jobs[ct] = ch
+		wg.Add(settings.concurrency)
+		for i := 0; i < settings.concurrency; i++ {
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					case res := <-ch:
+						if err := w.fetch(ctx, done, out, res); errors.Is(err, errWatcherDone) {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}
+	return jobs
+}
+
+// enqueue sends the content to the fetchers of its content type,
+// returning errWatcherDone once done is closed.
+func enqueue(done chan struct{}, jobs map[schema.ContentType]chan ResourceContent, res ResourceContent) error {
+	select {
+	case <-done:
+		return errWatcherDone
+	case jobs[*res.ContentType] <- res:
+		return nil
+	}
+}
+
+// receive queues the content of notifications until done is closed.
+func (w *WebhookWatcher) receive(done chan struct{}, jobs map[schema.ContentType]chan ResourceContent) {
+	notifications := w.notifications
+	for {
+		select {
+		case <-done:
+			return
+		case c, ok := <-notifications:
+			if !ok {
+				w.logger.Warn("receive: notifications channel closed, reconciling only")
+				notifications = nil
+				continue
+			}
+			ct, err := schema.GetContentType(c.ContentType)
+			if err != nil {
+				w.logger.Error("receive: mapping contentType", "content-id", c.ContentID, "error", err)
+				continue
+			}
+			if !w.watches(ct) {
+				w.logger.Debug("receive: content-type not watched, skipping", "content-type", ct.String())
+				continue
+			}
+			if err := enqueue(done, jobs, ResourceContent{ct, time.Now(), c}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reconcile lists the content of every watched subscription every TickerIntervalSeconds
+// of its content type, and queues the content missed by notifications, until done is closed.
+func (w *WebhookWatcher) reconcile(ctx context.Context, done chan struct{}, jobs map[schema.ContentType]chan ResourceContent) {
+	schedule := w.newPollSchedule()
+	ticker := time.NewTicker(schedule.tick)
+	defer ticker.Stop()
+
+	fetch := func(t time.Time) error {
+		w.logger.Debug("reconcile: start")
+		for sub := range w.fetchSubscriptions(ctx, done, t) {
+			if !w.watches(sub.ContentType) || !schedule.due(*sub.ContentType, t) {
+				continue
+			}
+			schedule.polled(*sub.ContentType, t)
+			for res := range w.fetchContent(ctx, done, sub, nil) {
+				if err := enqueue(done, jobs, res); err != nil {
+					return err
+				}
+			}
+		}
+		w.flush(ctx)
+		w.logger.Debug("reconcile: end")
+		return nil
+	}
+
+	if err := fetch(time.Now()); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case t := <-ticker.C:
+			if err := fetch(t); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// fetch sends the audits of a content blob to out, unless it was already fetched
+// or is being fetched by the other path.
+// Only errWatcherDone is returned, other errors are logged.
+func (w *WebhookWatcher) fetch(ctx context.Context, done chan struct{}, out chan ResourceAudits, res ResourceContent) error {
+	ctLogger := w.logger.With("content-type", res.ContentType.String(), "content-id", res.Content.ContentID)
+	if !w.claim(ctx, res.Content.ContentID) {
+		ctLogger.Debug("fetch: content skipped")
+		return nil
+	}
+	defer w.release(res.Content.ContentID)
+
+	ctLogger.Debug("fetch: content fetching..")
+	err := w.streamAudits(ctx, res, w.sendTracked(ctx, done, out, nil, res.Content.ContentID))
+	switch {
+	case err == nil:
+	case errors.Is(err, errWatcherDone):
+		return err
+	case errors.Is(err, context.Canceled):
+		return nil
+	case errors.Is(err, ErrContentExpired), errors.Is(err, ErrContentNotFound):
+		ctLogger.Warn("fetch: content dropped", "error", err)
+	default:
+		// not recorded as seen, for the next reconciliation to retry
+		ctLogger.Error("fetch: could not fetch audits", "error", err)
+		return nil
+	}
+
+	expiration, perr := time.ParseInLocation(CreatedDatetimeFormat, res.Content.ContentExpiration, time.Local)
+	if perr != nil {
+		expiration = time.Now().Add(defaultContentTTL)
+	}
+	if err := w.dedup.SetContentSeen(ctx, res.Content.ContentID, expiration); err != nil {
+		ctLogger.Error("fetch: could not set content seen", "error", err)
+	}
+	if created, perr := time.ParseInLocation(CreatedDatetimeFormat, res.Content.ContentCreated, time.Local); perr == nil {
+		if err := setLastContentCreated(ctx, w.State, *res.ContentType, created); err != nil {
+			ctLogger.Error("fetch: could not set lastContentCreated", "error", err)
+		}
+	}
+	ctLogger.Debug("fetch: end")
+	return nil
+}
+
+// claim reports whether the content must be fetched, marking it as being fetched if so.
+// Content is fetched if State fails, so that it is not lost.
+func (w *WebhookWatcher) claim(ctx context.Context, id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.inflight[id]; ok {
+		return false
+	}
+	seen, err := w.dedup.ContentSeen(ctx, id)
+	if err != nil {
+		w.logger.Error("claim: could not get content seen", "content-id", id, "error", err)
+	}
+	if seen {
+		return false
+	}
+	w.inflight[id] = struct{}{}
+	return true
+}
+
+func (w *WebhookWatcher) release(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.inflight, id)
+}
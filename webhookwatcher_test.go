@@ -0,0 +1,189 @@
+package office365
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orlangure/go-office365/office365test"
+	"github.com/orlangure/go-office365/schema"
+)
+
+func TestWebhookWatcherConfig(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Config  SubscriptionWatcherConfig
+		WantErr bool
+	}{
+		{"defaults", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5}, false},
+		{"overrides", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral: {TickerIntervalSeconds: 60, Concurrency: 4},
+		}}, false},
+		{"invalid override", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral: {Concurrency: -1},
+		}}, true},
+		{"at least once", SubscriptionWatcherConfig{LookBehindMinutes: 5, TickerIntervalSeconds: 5, AtLeastOnce: true}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := NewWebhookWatcher(nil, tc.Config, nil, NewMemoryState(), nil, nil)
+			if (err != nil) != tc.WantErr {
+				t.Errorf("got error %v but want error %v", err, tc.WantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookWatcher(t *testing.T) {
+	notifications := make(chan Content)
+	hook := httptest.NewServer(NewWebhookChanHandler("secret", notifications))
+	defer hook.Close()
+
+	server := office365test.NewServer()
+	defer server.Close()
+	client := NewClient(nil, "tenant", "")
+	client.BaseURL = server.BaseURL()
+
+	// added before the subscription starts, only found by reconciliation
+	ct := schema.AuditExchange
+	missed, err := server.AddContent("tenant", ct, time.Now().Add(-10*time.Minute), schema.AuditRecord{ID: String("missed")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.Subscription.Start(context.Background(), &ct, &Webhook{Address: String(hook.URL), AuthID: String("secret")})
+	if err != nil {
+		t.Fatalf("error occurred running Subscriptions.Start: %v", err)
+	}
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	state := NewMemoryState()
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 1}
+	watcher, err := NewWebhookWatcher(client, conf, notifications, state, handler, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = watcher.Run(ctx) }()
+
+	recordID := func() string {
+		t.Helper()
+		select {
+		case res := <-handler.ch:
+			record, ok := res.AuditRecord.(schema.AuditRecord)
+			if !ok || record.ID == nil {
+				t.Fatalf("got unexpected record %#v", res.AuditRecord)
+			}
+			return *record.ID
+		case <-time.After(5 * time.Second):
+			t.Fatal("no record received")
+		}
+		return ""
+	}
+	if got := recordID(); got != "missed" {
+		t.Errorf("got record %s but want missed", got)
+	}
+
+	notified, err := server.AddContent("tenant", ct, time.Now(), schema.AuditRecord{ID: String("notified")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordID(); got != "notified" {
+		t.Errorf("got record %s but want notified", got)
+	}
+
+	// let a reconciliation list both blobs again
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case res := <-handler.ch:
+		t.Errorf("got duplicate record %#v", res.AuditRecord)
+	default:
+	}
+	if got := server.Requests(office365test.OperationAuditFetch); got != 2 {
+		t.Errorf("got %d audit requests but want 2", got)
+	}
+	for _, id := range []string{missed, notified} {
+		if seen, _ := state.ContentSeen(context.Background(), id); !seen {
+			t.Errorf("content %s is not recorded in state", id)
+		}
+	}
+}
+
+func TestWebhookWatcherTickerInterval(t *testing.T) {
+	server, client := newTestServer(t)
+	server.StartSubscription("tenant", schema.AuditExchange)
+
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := SubscriptionWatcherConfig{
+		LookBehindMinutes:     60,
+		TickerIntervalSeconds: 1,
+		ContentTypes: map[schema.ContentType]ContentTypeConfig{
+			schema.AuditGeneral:  {},
+			schema.AuditExchange: {TickerIntervalSeconds: 60},
+		},
+	}
+	watcher, err := NewWebhookWatcher(client, conf, nil, NewMemoryState(), handler, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = watcher.Run(ctx) }()
+
+	// subscriptions are listed every second, AuditExchange content once a minute
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Requests(office365test.OperationSubscriptionList) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions are not listed every second")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := server.Requests(office365test.OperationContentList); got != 1 {
+		t.Errorf("got %d content requests but want 1", got)
+	}
+}
+
+func TestWebhookWatcherConcurrency(t *testing.T) {
+	// the first blob is held until the records of the second one are received
+	var held string
+	release := make(chan struct{})
+	hold := func(info RequestInfo, req *http.Request, next Handler) (*http.Response, error) {
+		if info.Operation == OperationAuditFetch && strings.HasSuffix(req.URL.Path, held) {
+			<-release
+		}
+		return next(req)
+	}
+	server, client := newTestServer(t, WithInterceptors(hold))
+
+	ct := schema.AuditExchange
+	now := time.Now()
+	held = addRecords(t, server, ct, now.Add(-10*time.Minute), "1")
+	second := addRecords(t, server, ct, now.Add(-5*time.Minute), "2")
+
+	notifications := make(chan Content, 2)
+	handler := chanHandler{ch: make(chan ResourceAudits, 100)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conf := SubscriptionWatcherConfig{LookBehindMinutes: 60, TickerIntervalSeconds: 60, Concurrency: 2}
+	watcher, err := NewWebhookWatcher(client, conf, notifications, NewMemoryState(), handler, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = watcher.Run(ctx) }()
+
+	for _, id := range []string{held, second} {
+		notifications <- Content{ContentType: ct.String(), ContentID: id}
+	}
+	testDeep(t, receiveIDs(t, handler.ch, 1), []string{"2"})
+	close(release)
+	testDeep(t, receiveIDs(t, handler.ch, 1), []string{"1"})
+}